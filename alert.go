@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MetricMissingHRD   = "hrd.missing"
+	MetricCorruptedHRD = "hrd.corrupted"
+	MetricCountReplay  = "replay.count"
+	MetricCountHRD     = "hrd.count"
+	MetricCountVMU     = "vmu.count"
+	MetricPending      = "replay.pending"
+	MetricState        = "autobrm.state"
+)
+
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+const (
+	NotifyAlertFiring   = "alert.firing"
+	NotifyAlertResolved = "alert.resolved"
+)
+
+const DefaultAlertInterval = time.Minute
+
+// AlertRule fires when the value of its metric compared to Value (or Values
+// for in and not in) with Op is true. Value can be given as a duration for
+// the metrics measured in seconds. Channel restricts the rule to one HRD
+// channel, VMU/replay origin or monitored process.
+type AlertRule struct {
+	Name    string
+	Metric  string
+	Channel string
+	Op      string
+	Value   string
+	Values  []string
+}
+
+type AlertConfig struct {
+	Interval int
+	Rules    []AlertRule `toml:"rule"`
+}
+
+type Alert struct {
+	Id       int        `json:"id"`
+	Rule     string     `json:"rule"`
+	Subject  string     `json:"subject"`
+	State    string     `json:"state"`
+	Value    string     `json:"value"`
+	Text     string     `json:"text"`
+	When     time.Time  `json:"time"`
+	Resolved *time.Time `json:"resolved,omitempty"`
+}
+
+type AlertStore interface {
+	FetchAlerts(context.Context, Criteria) (int, []Alert, error)
+	RegisterAlert(context.Context, Alert) (Alert, error)
+	ResolveAlert(context.Context, Alert) (Alert, error)
+}
+
+// sample is the value of a metric for one subject (a channel, an origin or
+// a monitored process).
+type sample struct {
+	Subject string
+	Value   float64
+	Text    string
+}
+
+func (s sample) String() string {
+	if s.Text != "" {
+		return s.Text
+	}
+	return strconv.FormatFloat(s.Value, 'f', -1, 64)
+}
+
+type rule struct {
+	AlertRule
+	value float64
+	match func(sample) bool
+}
+
+func compileRule(r AlertRule) (rule, error) {
+	c := rule{AlertRule: r}
+	if r.Name == "" {
+		return c, fmt.Errorf("alert rule without name")
+	}
+	switch r.Metric {
+	case MetricMissingHRD, MetricCorruptedHRD, MetricCountReplay, MetricCountHRD, MetricCountVMU, MetricPending, MetricState:
+	default:
+		return c, fmt.Errorf("%s: unknown metric %s", r.Name, r.Metric)
+	}
+	switch op := strings.ToLower(strings.TrimSpace(r.Op)); op {
+	case "in", "not in":
+		if len(r.Values) == 0 {
+			return c, fmt.Errorf("%s: values expected for %s", r.Name, op)
+		}
+		c.match = func(s sample) bool {
+			var found bool
+			for _, v := range r.Values {
+				if strings.EqualFold(v, s.String()) {
+					found = true
+					break
+				}
+			}
+			return found == (op == "in")
+		}
+	case ">", ">=", "<", "<=", "==", "!=":
+		if r.Metric == MetricState {
+			return c, fmt.Errorf("%s: %s can only be checked with in or not in", r.Name, r.Metric)
+		}
+		v, err := parseRuleValue(r.Value)
+		if err != nil {
+			return c, fmt.Errorf("%s: %w", r.Name, err)
+		}
+		c.value = v
+		c.match = func(s sample) bool {
+			switch op {
+			case ">":
+				return s.Value > v
+			case ">=":
+				return s.Value >= v
+			case "<":
+				return s.Value < v
+			case "<=":
+				return s.Value <= v
+			case "==":
+				return s.Value == v
+			default:
+				return s.Value != v
+			}
+		}
+	default:
+		return c, fmt.Errorf("%s: unknown operator %s", r.Name, r.Op)
+	}
+	return c, nil
+}
+
+func parseRuleValue(str string) (float64, error) {
+	if v, err := strconv.ParseFloat(str, 64); err == nil {
+		return v, nil
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s", str)
+	}
+	return d.Seconds(), nil
+}
+
+func (r rule) describe(s sample) string {
+	str := r.Value
+	if len(r.Values) > 0 {
+		str = strings.Join(r.Values, ", ")
+	}
+	return fmt.Sprintf("%s{%s} = %s (%s %s)", r.Metric, s.Subject, s, r.Op, str)
+}
+
+// Alerter evaluates periodically its rules, registers the alerts firing or
+// resolved and sends them to its sinks.
+type Alerter struct {
+	db      Store
+	mons    []Monitor
+	rules   []rule
+	sinks   []Sink
+	every   time.Duration
+	timeout time.Duration
+
+	mu     sync.Mutex
+	active map[string]Alert
+}
+
+func NewAlerter(db Store, mons []Monitor, conf AlertConfig, sinks []Sink, timeout time.Duration) (*Alerter, error) {
+	a := Alerter{
+		db:      db,
+		mons:    mons,
+		sinks:   sinks,
+		every:   seconds(conf.Interval, DefaultAlertInterval),
+		timeout: timeout,
+	}
+	seen := make(map[string]struct{})
+	for _, r := range conf.Rules {
+		c, err := compileRule(r)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[r.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicate alert rule", r.Name)
+		}
+		seen[r.Name] = struct{}{}
+		a.rules = append(a.rules, c)
+	}
+	return &a, nil
+}
+
+func (a *Alerter) Run(ctx context.Context) {
+	queue := dispatch(ctx, a.db, a.sinks, a.timeout)
+	defer close(queue)
+
+	tick := time.NewTicker(a.every)
+	defer tick.Stop()
+	for {
+		ns, err := a.Evaluate(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fail to evaluate alerts: %s\n", err)
+		}
+		for _, x := range ns {
+			select {
+			case queue <- x:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// Evaluate checks all the rules and gives the notifications of the alerts that
+// started or stopped firing. A rule whose metric can not be measured keeps its
+// alerts as they are.
+func (a *Alerter) Evaluate(ctx context.Context) ([]Notification, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	if a.active == nil {
+		if err := a.loadActive(ctx); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		ns      []Notification
+		errs    []string
+		samples = make(map[string][]sample)
+	)
+	for _, r := range a.rules {
+		ss, ok := samples[r.Metric]
+		if !ok {
+			var err error
+			if ss, err = a.collect(ctx, r.Metric); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", r.Metric, err))
+				continue
+			}
+			samples[r.Metric] = ss
+		}
+		xs, err := a.evaluate(ctx, r, ss)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", r.Name, err))
+		}
+		ns = append(ns, xs...)
+	}
+	if len(errs) > 0 {
+		return ns, errors.New(strings.Join(errs, "; "))
+	}
+	return ns, nil
+}
+
+func (a *Alerter) evaluate(ctx context.Context, r rule, ss []sample) ([]Notification, error) {
+	var (
+		ns   []Notification
+		now  = time.Now().UTC()
+		seen = make(map[string]struct{})
+	)
+	for _, s := range ss {
+		if r.Channel != "" && r.Channel != s.Subject {
+			continue
+		}
+		key := alertKey(r.Name, s.Subject)
+		seen[key] = struct{}{}
+
+		x, firing := a.active[key]
+		if r.match(s) == firing {
+			continue
+		}
+		if firing {
+			x, err := a.resolve(ctx, key, x, now)
+			if err != nil {
+				return ns, err
+			}
+			ns = append(ns, Notification{Type: NotifyAlertResolved, When: now, Data: x})
+			continue
+		}
+		x = Alert{
+			Rule:    r.Name,
+			Subject: s.Subject,
+			State:   AlertFiring,
+			Value:   s.String(),
+			Text:    r.describe(s),
+			When:    now,
+		}
+		x, err := a.db.RegisterAlert(ctx, x)
+		if err != nil {
+			return ns, err
+		}
+		a.active[key] = x
+		ns = append(ns, Notification{Type: NotifyAlertFiring, When: now, Data: x})
+	}
+	for key, x := range a.active {
+		if _, ok := seen[key]; ok || x.Rule != r.Name {
+			continue
+		}
+		x, err := a.resolve(ctx, key, x, now)
+		if err != nil {
+			return ns, err
+		}
+		ns = append(ns, Notification{Type: NotifyAlertResolved, When: now, Data: x})
+	}
+	return ns, nil
+}
+
+func (a *Alerter) resolve(ctx context.Context, key string, x Alert, when time.Time) (Alert, error) {
+	x.State = AlertResolved
+	x.Resolved = &when
+	x, err := a.db.ResolveAlert(ctx, x)
+	if err == nil {
+		delete(a.active, key)
+	}
+	return x, err
+}
+
+func (a *Alerter) loadActive(ctx context.Context) error {
+	_, as, err := a.db.FetchAlerts(ctx, Criteria{Status: AlertFiring})
+	if err != nil && !errors.Is(err, ErrEmpty) {
+		return err
+	}
+	a.active = make(map[string]Alert)
+	for _, x := range as {
+		a.active[alertKey(x.Rule, x.Subject)] = x
+	}
+	return nil
+}
+
+func alertKey(rule, subject string) string {
+	return rule + "/" + subject
+}
+
+func (a *Alerter) collect(ctx context.Context, metric string) ([]sample, error) {
+	// the counts are grouped by the date of the database: local time
+	today := time.Now().Format("2006-01-02")
+	switch metric {
+	case MetricMissingHRD, MetricCorruptedHRD:
+		is, err := a.db.FetchStatusHRD(ctx, 1)
+		if err != nil && !errors.Is(err, ErrEmpty) {
+			return nil, err
+		}
+		label := strings.ToUpper(strings.TrimPrefix(metric, "hrd."))
+		values := make(map[string]float64)
+		for _, i := range is {
+			if i.Label == label && i.When.Format("2006-01-02") == today {
+				values[i.Channel] += float64(i.Count)
+			}
+		}
+		return samplesFromMap(values), nil
+	case MetricCountReplay, MetricCountHRD, MetricCountVMU:
+		is, err := a.db.FetchCounts(ctx, 1)
+		if err != nil && !errors.Is(err, ErrEmpty) {
+			return nil, err
+		}
+		label := strings.ToUpper(strings.TrimSuffix(metric, ".count"))
+		values := make(map[string]float64)
+		for _, i := range is {
+			if i.Label == label && i.When.Format("2006-01-02") == today {
+				values[i.Origin] += float64(i.Count)
+			}
+		}
+		return samplesFromMap(values), nil
+	case MetricPending:
+		d, err := a.db.FetchPendingAge(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []sample{{Subject: "replay", Value: float64(d)}}, nil
+	case MetricState:
+		var ss []sample
+		for _, m := range a.mons {
+			state := m.readProcess()["state"]
+			if fs := strings.Fields(state); len(fs) > 0 {
+				state = fs[0]
+			}
+			ss = append(ss, sample{Subject: m.Name, Text: state})
+		}
+		return ss, nil
+	default:
+		return nil, fmt.Errorf("unknown metric")
+	}
+}
+
+func samplesFromMap(values map[string]float64) []sample {
+	ss := make([]sample, 0, len(values))
+	for k, v := range values {
+		ss = append(ss, sample{Subject: k, Value: v})
+	}
+	return ss
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompileRule(t *testing.T) {
+	data := []struct {
+		AlertRule
+		Valid bool
+	}{
+		{AlertRule: AlertRule{Name: "missing", Metric: MetricMissingHRD, Op: ">", Value: "1000"}, Valid: true},
+		{AlertRule: AlertRule{Name: "pending", Metric: MetricPending, Op: ">", Value: "6h"}, Valid: true},
+		{AlertRule: AlertRule{Name: "state", Metric: MetricState, Op: "not in", Values: []string{"R", "S"}}, Valid: true},
+		{AlertRule: AlertRule{Metric: MetricPending, Op: ">", Value: "6h"}},
+		{AlertRule: AlertRule{Name: "unknown", Metric: "cpu", Op: ">", Value: "1"}},
+		{AlertRule: AlertRule{Name: "op", Metric: MetricPending, Op: "~", Value: "1"}},
+		{AlertRule: AlertRule{Name: "value", Metric: MetricPending, Op: ">", Value: "soon"}},
+		{AlertRule: AlertRule{Name: "values", Metric: MetricState, Op: "in"}},
+		{AlertRule: AlertRule{Name: "state", Metric: MetricState, Op: "==", Value: "R"}},
+	}
+	for _, d := range data {
+		_, err := compileRule(d.AlertRule)
+		if d.Valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+		}
+		if !d.Valid && err == nil {
+			t.Errorf("%s: expected error", d.Name)
+		}
+	}
+	r, _ := compileRule(AlertRule{Name: "pending", Metric: MetricPending, Op: ">", Value: "6h"})
+	if r.value != 6*3600 {
+		t.Errorf("unexpected value: want %d, got %f", 6*3600, r.value)
+	}
+}
+
+func TestAlerter(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		db   = newMemStore()
+		dir  = t.TempDir()
+		now  = time.Now().UTC()
+		conf = AlertConfig{
+			Rules: []AlertRule{
+				{Name: "missing", Metric: MetricMissingHRD, Channel: "vic1", Op: ">", Value: "5"},
+				{Name: "pending", Metric: MetricPending, Op: ">=", Value: "1h"},
+				{Name: "state", Metric: MetricState, Op: "not in", Values: []string{"R", "S"}},
+			},
+		}
+	)
+	setState := func(state string) {
+		t.Helper()
+		status := "Name:\tautobrm\nState:\t" + state + "\nPid:\t42\n"
+		if err := os.WriteFile(filepath.Join(dir, "42", "status"), []byte(status), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "42"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "autobrm.pid"), []byte("42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setState("Z (zombie)")
+	db.seedGapHRD(HRDGap{
+		Gap:     Gap{First: 10, Last: 30, Period: Period{Starts: now.Add(-time.Second), Ends: now}},
+		Channel: "vic1",
+	})
+	mon := Monitor{Name: "autobrm", Pid: filepath.Join(dir, "autobrm.pid"), Proc: dir}
+
+	a, err := NewAlerter(db, []Monitor{mon}, conf, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns, err := a.Evaluate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 3 {
+		t.Fatalf("unexpected number of notifications: want 3, got %d", len(ns))
+	}
+	for _, x := range ns {
+		if x.Type != NotifyAlertFiring {
+			t.Errorf("unexpected notification: %s", x.Type)
+		}
+	}
+	if ns, _ = a.Evaluate(ctx); len(ns) != 0 {
+		t.Fatalf("alerts notified twice: %v", ns)
+	}
+
+	a, err = NewAlerter(db, []Monitor{mon}, conf, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns, _ = a.Evaluate(ctx); len(ns) != 0 {
+		t.Fatalf("firing alerts not loaded from store: %v", ns)
+	}
+
+	setState("S (sleeping)")
+	ns, err = a.Evaluate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 1 || ns[0].Type != NotifyAlertResolved {
+		t.Fatalf("unexpected notifications: %v", ns)
+	}
+	if x := ns[0].Data.(Alert); x.Rule != "state" || x.Resolved == nil {
+		t.Errorf("unexpected resolved alert: %+v", x)
+	}
+	if _, as, _ := db.FetchAlerts(ctx, Criteria{Status: AlertFiring}); len(as) != 2 {
+		t.Errorf("unexpected number of firing alerts: want 2, got %d", len(as))
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const anonymous = "anonymous"
+
+// actorFromContext gives the name of the user attached to ctx or anonymous if
+// requests are not authenticated.
+func actorFromContext(ctx context.Context) string {
+	if u, ok := userFromContext(ctx); ok {
+		return u.Name
+	}
+	return anonymous
+}
+
+type Audit struct {
+	Id     int             `json:"id"`
+	When   time.Time       `json:"time"`
+	Actor  string          `json:"actor"`
+	Action string          `json:"action"`
+	Target string          `json:"target"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+	Origin string          `json:"origin"`
+}
+
+type AuditStore interface {
+	RegisterAudit(context.Context, Audit) error
+	FetchAudits(context.Context, Criteria) (int, []Audit, error)
+}
+
+// audit records the action done by the given handler when it succeeds. The
+// state of the target before the action is given by before (if not nil). The
+// origin of the request is given by X-Forwarded-For only when the request is
+// sent by one of the proxies. When failures is set, the failed attempts are
+// recorded too as action.failed with the error as state after.
+func audit(db AuditStore, proxies []*net.IPNet, action string, failures bool, before Handler, do Handler) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		var prev interface{}
+		if before != nil {
+			if p, err := before(r); err == nil {
+				prev = p
+			}
+		}
+		data, err := do(r)
+		if err != nil && !failures {
+			return data, err
+		}
+		a := Audit{
+			When:   time.Now().UTC(),
+			Actor:  actorFromContext(r.Context()),
+			Action: action,
+			Target: auditTarget(r, data),
+			Origin: requestOrigin(r, proxies),
+		}
+		if prev != nil {
+			a.Before, _ = json.Marshal(auditValue(prev))
+		}
+		if err != nil {
+			a.Action += ".failed"
+			a.After, _ = json.Marshal(map[string]string{"error": err.Error()})
+		} else if data != nil {
+			a.After, _ = json.Marshal(auditValue(data))
+		}
+		if err := db.RegisterAudit(r.Context(), a); err != nil {
+			fmt.Fprintf(os.Stderr, "fail to register audit for %s: %s\n", a.Action, err)
+		}
+		return data, err
+	}
+}
+
+// auditValue gives v without the secrets it could hold.
+func auditValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case PendingChange:
+		v.Token = ""
+		return v
+	case PendingAction:
+		v.Token = ""
+		return v
+	default:
+		return v
+	}
+}
+
+// auditTarget gives the id of the target of the request, the process and the
+// action controlled or the ids of the replays registered, comma separated.
+func auditTarget(r *http.Request, data interface{}) string {
+	vars := mux.Vars(r)
+	if id, ok := vars[fieldId]; ok {
+		return id
+	}
+	if p, ok := vars[fieldProcess]; ok {
+		return p + "/" + vars[fieldAction]
+	}
+	switch v := data.(type) {
+	case Replay:
+		return strconv.Itoa(v.Id)
+	case []Replay:
+		ids := make([]string, 0, len(v))
+		for _, r := range v {
+			ids = append(ids, strconv.Itoa(r.Id))
+		}
+		return strings.Join(ids, ",")
+	case Variable:
+		return strconv.Itoa(v.Id)
+	default:
+		return ""
+	}
+}
+
+// requestOrigin gives the address of the client. When the request is sent by
+// a trusted proxy, it is the last address of X-Forwarded-For not given by a
+// trusted proxy.
+func requestOrigin(r *http.Request, proxies []*net.IPNet) string {
+	fwd := r.Header.Get("X-Forwarded-For")
+	if fwd == "" || !isTrusted(r.RemoteAddr, proxies) {
+		return r.RemoteAddr
+	}
+	parts := strings.Split(fwd, ",")
+	for i := len(parts) - 1; i > 0; i-- {
+		addr := strings.TrimSpace(parts[i])
+		if !isTrusted(addr, proxies) {
+			return addr
+		}
+	}
+	return strings.TrimSpace(parts[0])
+}
+
+func isTrusted(addr string, proxies []*net.IPNet) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func currentReplay(db ReplayStore) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		id, err := parseInt(r, fieldId)
+		if err != nil {
+			return nil, err
+		}
+		rp, err := db.FetchReplayDetail(r.Context(), id)
+		return rp.Replay, err
+	}
+}
+
+func currentVariable(db ConfigStore) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		id, err := parseInt(r, fieldId)
+		if err != nil {
+			return nil, err
+		}
+		return db.FetchVariable(r.Context(), id)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	var conf Config
+	conf.Auth.Tokens = []TokenConfig{
+		{User: "operator", Token: "abcdef"},
+		{User: "admin", Token: "fedcba"},
+	}
+	conf.Auth.Operators = []string{"operator"}
+	conf.Auth.Admins = []string{"admin"}
+	conf.Auth.Proxies = []string{"192.0.2.0/24", "10.0.0.2"}
+
+	db := newMemStore()
+	handler, err := setupRoutes(db, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := newRequest(http.MethodPut, "/requests/1", `{"priority": 5}`)
+	req.Header.Set("Authorization", "Bearer abcdef")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1, 10.0.0.2")
+	if code := serve(handler, req); code != http.StatusOK {
+		t.Fatalf("unexpected status code: want %d, got %d", http.StatusOK, code)
+	}
+	req = newRequest(http.MethodPut, "/requests/99", `{"priority": 5}`)
+	req.Header.Set("Authorization", "Bearer abcdef")
+	if code := serve(handler, req); code != http.StatusNotFound {
+		t.Fatalf("unexpected status code: want %d, got %d", http.StatusNotFound, code)
+	}
+	if len(db.audits) != 1 {
+		t.Fatalf("unexpected number of audits: want 1, got %d", len(db.audits))
+	}
+	a := db.audits[0]
+	if a.Actor != "operator" || a.Action != "replay.update" || a.Target != "1" || a.Origin != "10.0.0.1" {
+		t.Errorf("unexpected audit: %+v", a)
+	}
+	var before, after Replay
+	if err := json.Unmarshal(a.Before, &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(a.After, &after); err != nil {
+		t.Fatal(err)
+	}
+	if before.Priority != 1 || after.Priority != 5 {
+		t.Errorf("unexpected priorities: want 1/5, got %d/%d", before.Priority, after.Priority)
+	}
+
+	req = newRequest(http.MethodGet, "/audit/", "")
+	req.Header.Set("Authorization", "Bearer abcdef")
+	if code := serve(handler, req); code != http.StatusForbidden {
+		t.Errorf("unexpected status code: want %d, got %d", http.StatusForbidden, code)
+	}
+	req = newRequest(http.MethodGet, "/audit/", "")
+	req.Header.Set("Authorization", "Bearer fedcba")
+	if code := serve(handler, req); code != http.StatusOK {
+		t.Errorf("unexpected status code: want %d, got %d", http.StatusOK, code)
+	}
+}
+
+func TestRequestOrigin(t *testing.T) {
+	proxies, err := AuthConfig{Proxies: []string{"192.0.2.0/24", "10.0.0.2"}}.TrustedProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []struct {
+		Remote string
+		Header string
+		Want   string
+	}{
+		{Remote: "198.51.100.1:1234", Want: "198.51.100.1:1234"},
+		{Remote: "198.51.100.1:1234", Header: "10.0.0.1", Want: "198.51.100.1:1234"},
+		{Remote: "192.0.2.1:1234", Header: "10.0.0.1", Want: "10.0.0.1"},
+		{Remote: "192.0.2.1:1234", Header: "10.0.0.9, 10.0.0.1, 10.0.0.2", Want: "10.0.0.1"},
+		{Remote: "192.0.2.1:1234", Header: "10.0.0.2", Want: "10.0.0.2"},
+	}
+	for _, d := range data {
+		req := newRequest(http.MethodGet, "/", "")
+		req.RemoteAddr = d.Remote
+		if d.Header != "" {
+			req.Header.Set("X-Forwarded-For", d.Header)
+		}
+		if got := requestOrigin(req, proxies); got != d.Want {
+			t.Errorf("%s (%s): unexpected origin: want %s, got %s", d.Remote, d.Header, d.Want, got)
+		}
+	}
+}
+
+func TestAuditValue(t *testing.T) {
+	p := PendingChange{Id: 1, Value: "manual", Token: "secret"}
+	if v := auditValue(p).(PendingChange); v.Token != "" {
+		t.Errorf("token kept in audit: %s", v.Token)
+	}
+	if p.Token != "secret" {
+		t.Errorf("token removed from the response")
+	}
+	req := newRequest(http.MethodPost, "/requests/", "")
+	if got := auditTarget(req, []Replay{{Id: 3}, {Id: 4}}); got != "3,4" {
+		t.Errorf("unexpected target: want 3,4, got %s", got)
+	}
+}
+
+func TestAuditControl(t *testing.T) {
+	var conf Config
+	conf.Mon = Monitor{
+		Pid: filepath.Join(t.TempDir(), "autobrm.pid"),
+		Actions: []ControlAction{
+			{Name: "reload", Signal: "HUP"},
+			{Name: "fail", Command: []string{"sh", "-c", "echo broken; exit 1"}, Expect: ExpectRunning},
+		},
+	}
+	if _, err := setupRoutes(newMemStore(), conf); err == nil {
+		t.Fatalf("actions configured without authentication")
+	}
+	conf.Auth.Tokens = []TokenConfig{{User: "admin", Token: "fedcba"}}
+	conf.Auth.Admins = []string{"admin"}
+
+	db := newMemStore()
+	handler, err := setupRoutes(db, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := newRequest(http.MethodPost, "/processes/autobrm/actions/reload", "")
+	req.Header.Set("Authorization", "Bearer fedcba")
+	if code := serve(handler, req); code != http.StatusCreated {
+		t.Fatalf("unexpected status code: want %d, got %d", http.StatusCreated, code)
+	}
+	req = newRequest(http.MethodPost, "/processes/autobrm/actions/reload/confirm", `{"token": "invalid"}`)
+	req.Header.Set("Authorization", "Bearer fedcba")
+	if code := serve(handler, req); code != http.StatusForbidden {
+		t.Fatalf("unexpected status code: want %d, got %d", http.StatusForbidden, code)
+	}
+	if len(db.audits) != 2 {
+		t.Fatalf("unexpected number of audits: want 2, got %d", len(db.audits))
+	}
+	if a := db.audits[0]; a.Action != "process.request" || a.Target != "autobrm/reload" {
+		t.Errorf("unexpected audit of request: %+v", a)
+	}
+	var p PendingAction
+	if err := json.Unmarshal(db.audits[0].After, &p); err != nil || p.Token != "" {
+		t.Errorf("token kept in audit: %s", db.audits[0].After)
+	}
+	if a := db.audits[1]; a.Action != "process.control.failed" || a.Target != "autobrm/reload" || len(a.After) == 0 {
+		t.Errorf("unexpected audit of failed confirm: %+v", a)
+	}
+
+	rec := httptest.NewRecorder()
+	req = newRequest(http.MethodPost, "/processes/autobrm/actions/fail", "")
+	req.Header.Set("Authorization", "Bearer fedcba")
+	handler.ServeHTTP(rec, req)
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	req = newRequest(http.MethodPost, "/processes/autobrm/actions/fail/confirm", `{"token": "`+p.Token+`"}`)
+	req.Header.Set("Authorization", "Bearer fedcba")
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("failed command: unexpected status code: want %d, got %d", http.StatusInternalServerError, rec.Code)
+	}
+	var c struct {
+		Result ControlResult `json:"result"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&c); err != nil || c.Result.Output != "broken" {
+		t.Errorf("output of failed command not given back: %+v (%v)", c.Result, err)
+	}
+	if a := db.audits[len(db.audits)-1]; a.Action != "process.control.failed" || a.Target != "autobrm/fail" {
+		t.Errorf("unexpected audit of failed command: %+v", a)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Role int
+
+const (
+	RoleViewer Role = iota
+	RoleOperator
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "unknown"
+	}
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+type User struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+}
+
+type Authenticator interface {
+	Authenticate(*http.Request) (User, error)
+}
+
+type TokenConfig struct {
+	User  string
+	Token string
+}
+
+type AuthConfig struct {
+	Public    bool
+	Users     string        `toml:"users"`
+	Tokens    []TokenConfig `toml:"token"`
+	Operators []string
+	Admins    []string
+	Confirm   int
+	Proxies   []string
+}
+
+const DefaultConfirmWindow = time.Minute * 5
+
+// ConfirmWindow gives the time (configured in seconds) during which the author
+// of a change to an hazardous variable can confirm it with its token.
+func (c AuthConfig) ConfirmWindow() time.Duration {
+	if c.Confirm <= 0 {
+		return DefaultConfirmWindow
+	}
+	return time.Duration(c.Confirm) * time.Second
+}
+
+// TrustedProxies gives the networks of the proxies (given by address or in
+// CIDR notation) trusted to give the address of the client.
+func (c AuthConfig) TrustedProxies() ([]*net.IPNet, error) {
+	var ns []*net.IPNet
+	for _, p := range c.Proxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid proxy", p)
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+// Authenticator gives the Authenticator configured by c. It gives nil if no
+// tokens nor users file are configured, meaning that authentication is
+// disabled.
+func (c AuthConfig) Authenticator() (Authenticator, error) {
+	var as multiAuth
+	if len(c.Tokens) > 0 {
+		ts := make(tokenAuth)
+		for _, t := range c.Tokens {
+			if t.Token == "" || t.User == "" {
+				return nil, fmt.Errorf("auth: token without user or value")
+			}
+			ts[t.Token] = User{Name: t.User}
+		}
+		as = append(as, ts)
+	}
+	if c.Users != "" {
+		us, err := loadPasswdFile(c.Users)
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, us)
+	}
+	if len(as) == 0 {
+		return nil, nil
+	}
+	ra := roleAuth{
+		Authenticator: as,
+		roles:         make(map[string]Role),
+	}
+	for _, n := range c.Operators {
+		ra.roles[n] = RoleOperator
+	}
+	for _, n := range c.Admins {
+		ra.roles[n] = RoleAdmin
+	}
+	return ra, nil
+}
+
+// roleAuth gives their role to the users identified by the wrapped
+// Authenticator. Users without an explicit role are viewers.
+type roleAuth struct {
+	Authenticator
+	roles map[string]Role
+}
+
+func (r roleAuth) Authenticate(req *http.Request) (User, error) {
+	u, err := r.Authenticator.Authenticate(req)
+	if err == nil {
+		u.Role = r.roles[u.Name]
+	}
+	return u, err
+}
+
+// tokenAuth authenticates requests with static tokens given in the
+// Authorization header with the Bearer scheme.
+type tokenAuth map[string]User
+
+func (t tokenAuth) Authenticate(r *http.Request) (User, error) {
+	var (
+		auth   = r.Header.Get("Authorization")
+		prefix = "Bearer "
+	)
+	if !strings.HasPrefix(auth, prefix) {
+		return User{}, ErrAuth
+	}
+	token := []byte(strings.TrimPrefix(auth, prefix))
+	for k, u := range t {
+		if subtle.ConstantTimeCompare([]byte(k), token) == 1 {
+			return u, nil
+		}
+	}
+	return User{}, ErrAuth
+}
+
+// passwdAuth authenticates requests with basic authentication against the
+// bcrypt hashes of an htpasswd file.
+type passwdAuth map[string][]byte
+
+func loadPasswdFile(file string) (passwdAuth, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var (
+		users = make(passwdAuth)
+		scan  = bufio.NewScanner(r)
+		line  int
+	)
+	for scan.Scan() {
+		line++
+		str := strings.TrimSpace(scan.Text())
+		if str == "" || strings.HasPrefix(str, "#") {
+			continue
+		}
+		parts := strings.SplitN(str, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("%s:%d: invalid line", file, line)
+		}
+		if !strings.HasPrefix(parts[1], "$2") {
+			return nil, fmt.Errorf("%s:%d: only bcrypt passwords are supported", file, line)
+		}
+		users[parts[0]] = []byte(parts[1])
+	}
+	return users, scan.Err()
+}
+
+func (p passwdAuth) Authenticate(r *http.Request) (User, error) {
+	name, passwd, ok := r.BasicAuth()
+	if !ok {
+		return User{}, ErrAuth
+	}
+	hash, ok := p[name]
+	if !ok {
+		return User{}, ErrAuth
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(passwd)); err != nil {
+		return User{}, ErrAuth
+	}
+	return User{Name: name}, nil
+}
+
+type multiAuth []Authenticator
+
+func (m multiAuth) Authenticate(r *http.Request) (User, error) {
+	for _, a := range m {
+		u, err := a.Authenticate(r)
+		if err == nil {
+			return u, nil
+		}
+	}
+	return User{}, ErrAuth
+}
+
+type userKey struct{}
+
+func userFromContext(ctx context.Context) (User, bool) {
+	u, ok := ctx.Value(userKey{}).(User)
+	return u, ok
+}
+
+func authorize(auth Authenticator, role Role, do Handler) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		r, err := authenticate(auth, role, r)
+		if err != nil {
+			return nil, err
+		}
+		return do(r)
+	}
+}
+
+// authorizeStream is like authorize for the handlers that write their
+// response themselves.
+func authorizeStream(auth Authenticator, role Role, next http.Handler) http.Handler {
+	do := func(w http.ResponseWriter, r *http.Request) {
+		r, err := authenticate(auth, role, r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(do)
+}
+
+// authenticate checks that the user of r has at least the given role and
+// gives a copy of r with the user attached to its context.
+func authenticate(auth Authenticator, role Role, r *http.Request) (*http.Request, error) {
+	u, err := auth.Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	if u.Role < role {
+		return nil, fmt.Errorf("%w: %s role required", ErrForbidden, role)
+	}
+	ctx := context.WithValue(r.Context(), userKey{}, u)
+	return r.WithContext(ctx), nil
+}
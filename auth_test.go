@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthenticate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(file, []byte("# otto users\noperator:"+string(hash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var conf Config
+	conf.Auth.Public = true
+	conf.Auth.Users = file
+	conf.Auth.Tokens = append(conf.Auth.Tokens, TokenConfig{User: "dashboard", Token: "abcdef"})
+	conf.Auth.Tokens = append(conf.Auth.Tokens, TokenConfig{User: "admin", Token: "fedcba"})
+	conf.Auth.Operators = []string{"dashboard", "operator"}
+	conf.Auth.Admins = []string{"admin"}
+
+	handler, err := setupRoutes(newMemStore(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []struct {
+		Method string
+		URL    string
+		Body   string
+		Auth   func(*http.Request)
+		Code   int
+	}{
+		{Method: http.MethodGet, URL: "/requests/", Code: http.StatusOK},
+		{Method: http.MethodPut, URL: "/requests/1", Body: `{"priority": 5}`, Code: http.StatusUnauthorized},
+		{
+			Method: http.MethodPut,
+			URL:    "/requests/1",
+			Body:   `{"priority": 5}`,
+			Auth:   func(r *http.Request) { r.Header.Set("Authorization", "Bearer abcdef") },
+			Code:   http.StatusOK,
+		},
+		{
+			Method: http.MethodPut,
+			URL:    "/requests/1",
+			Body:   `{"priority": 5}`,
+			Auth:   func(r *http.Request) { r.Header.Set("Authorization", "Bearer foobar") },
+			Code:   http.StatusUnauthorized,
+		},
+		{
+			Method: http.MethodPut,
+			URL:    "/requests/1",
+			Body:   `{"priority": 5}`,
+			Auth:   func(r *http.Request) { r.SetBasicAuth("operator", "secret") },
+			Code:   http.StatusOK,
+		},
+		{
+			Method: http.MethodPut,
+			URL:    "/requests/1",
+			Body:   `{"priority": 5}`,
+			Auth:   func(r *http.Request) { r.SetBasicAuth("operator", "foobar") },
+			Code:   http.StatusUnauthorized,
+		},
+		{
+			Method: http.MethodPut,
+			URL:    "/config/1",
+			Body:   `{"value": "10"}`,
+			Auth:   func(r *http.Request) { r.SetBasicAuth("operator", "secret") },
+			Code:   http.StatusForbidden,
+		},
+		{
+			Method: http.MethodPut,
+			URL:    "/config/1",
+			Body:   `{"value": "10"}`,
+			Auth:   func(r *http.Request) { r.Header.Set("Authorization", "Bearer fedcba") },
+			Code:   http.StatusOK,
+		},
+	}
+	for _, d := range data {
+		req := newRequest(d.Method, d.URL, d.Body)
+		if d.Auth != nil {
+			d.Auth(req)
+		}
+		if code := serve(handler, req); code != d.Code {
+			t.Errorf("%s %s: unexpected status code: want %d, got %d", d.Method, d.URL, d.Code, code)
+		}
+	}
+}
+
+func TestLoadPasswdFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(file, []byte("operator:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPasswdFile(file); err == nil {
+		t.Errorf("expected error for non bcrypt password")
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	ExpectRunning   = "running"
+	ExpectStopped   = "stopped"
+	ExpectRestarted = "restarted"
+)
+
+const (
+	DefaultControlWait = 10 * time.Second
+	controlMargin      = 5 * time.Second
+	controlPoll        = 200 * time.Millisecond
+	maxControlOutput   = 4096
+)
+
+const (
+	fieldProcess = "process"
+	fieldAction  = "action"
+)
+
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+// ControlAction is an action that admins can run on a monitored process. It
+// either sends Signal to the process or runs Command (without shell). After
+// the action, the state of the process is checked during Wait seconds until
+// it is the one expected. The state expected after a command has to be given
+// since it can not be guessed from the command itself.
+type ControlAction struct {
+	Name    string
+	Signal  string
+	Command []string
+	Expect  string
+	Wait    int
+}
+
+func (a ControlAction) check() error {
+	if a.Name == "" {
+		return fmt.Errorf("action without name")
+	}
+	if (a.Signal == "") == (len(a.Command) == 0) {
+		return fmt.Errorf("%s: signal or command expected", a.Name)
+	}
+	if a.Signal != "" {
+		if _, err := parseSignal(a.Signal); err != nil {
+			return fmt.Errorf("%s: %w", a.Name, err)
+		}
+	}
+	if len(a.Command) > 0 && a.Expect == "" {
+		return fmt.Errorf("%s: expected state required for command", a.Name)
+	}
+	switch a.Expect {
+	case "", ExpectRunning, ExpectStopped, ExpectRestarted:
+	default:
+		return fmt.Errorf("%s: unknown expected state %s", a.Name, a.Expect)
+	}
+	return nil
+}
+
+// deadline gives the time given to the action to run and to the process to
+// reach the state expected.
+func (a ControlAction) deadline() time.Duration {
+	return seconds(a.Wait, DefaultControlWait) + controlMargin
+}
+
+func (a ControlAction) expected() string {
+	if a.Expect == "" {
+		return ExpectRunning
+	}
+	return a.Expect
+}
+
+func parseSignal(str string) (syscall.Signal, error) {
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(str), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported signal %s", str)
+	}
+	return sig, nil
+}
+
+// PendingAction is an action requested by an admin. It is only run when its
+// token is given back before it expires.
+type PendingAction struct {
+	Process string    `json:"process"`
+	Action  string    `json:"action"`
+	Author  string    `json:"author"`
+	When    time.Time `json:"time"`
+	Expires time.Time `json:"expires"`
+	Token   string    `json:"token"`
+}
+
+type ControlResult struct {
+	Process string            `json:"process"`
+	Action  string            `json:"action"`
+	Expect  string            `json:"expect"`
+	Done    bool              `json:"done"`
+	Reason  string            `json:"reason,omitempty"`
+	Output  string            `json:"output,omitempty"`
+	When    time.Time         `json:"time"`
+	Before  map[string]string `json:"before"`
+	After   map[string]string `json:"after"`
+}
+
+// ControlError reports the failure of the command of an action. It keeps the
+// result of the action to give the output of the command back.
+type ControlError struct {
+	Result ControlResult
+	Err    error
+}
+
+func (e ControlError) Error() string {
+	return fmt.Sprintf("%s of %s: command failed: %s", e.Result.Action, e.Result.Process, e.Err)
+}
+
+func (e ControlError) Unwrap() error {
+	return e.Err
+}
+
+// Controller runs the actions configured for the monitored processes once
+// they have been requested and confirmed.
+type Controller struct {
+	mons   map[string]Monitor
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]PendingAction
+}
+
+func NewController(mons []Monitor, window time.Duration) (*Controller, error) {
+	c := Controller{
+		mons:    make(map[string]Monitor),
+		window:  window,
+		pending: make(map[string]PendingAction),
+	}
+	for _, m := range mons {
+		seen := make(map[string]struct{})
+		for _, a := range m.Actions {
+			if err := a.check(); err != nil {
+				return nil, fmt.Errorf("%s: %w", m.Name, err)
+			}
+			if _, ok := seen[a.Name]; ok {
+				return nil, fmt.Errorf("%s: %s: duplicate action", m.Name, a.Name)
+			}
+			seen[a.Name] = struct{}{}
+		}
+		c.mons[m.Name] = m
+	}
+	return &c, nil
+}
+
+func (c *Controller) lookup(process, action string) (Monitor, ControlAction, error) {
+	m, ok := c.mons[process]
+	if !ok {
+		return m, ControlAction{}, fmt.Errorf("%w: process %s not found", ErrExist, process)
+	}
+	for _, a := range m.Actions {
+		if a.Name == action {
+			return m, a, nil
+		}
+	}
+	return m, ControlAction{}, fmt.Errorf("%w: action %s not found for %s", ErrExist, action, process)
+}
+
+// Configured reports whether at least one action is configured.
+func (c *Controller) Configured() bool {
+	for _, m := range c.mons {
+		if len(m.Actions) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Deadline gives the longest time needed to run one of the actions.
+func (c *Controller) Deadline() time.Duration {
+	var d time.Duration
+	for _, m := range c.mons {
+		for _, a := range m.Actions {
+			if x := a.deadline(); x > d {
+				d = x
+			}
+		}
+	}
+	return d
+}
+
+// Actions gives the actions configured for the given process.
+func (c *Controller) Actions(process string) ([]ControlAction, error) {
+	m, ok := c.mons[process]
+	if !ok {
+		return nil, fmt.Errorf("%w: process %s not found", ErrExist, process)
+	}
+	return m.Actions, nil
+}
+
+// Request registers the action for the user attached to ctx and gives the
+// token to confirm it.
+func (c *Controller) Request(ctx context.Context, process, action string) (PendingAction, error) {
+	if _, _, err := c.lookup(process, action); err != nil {
+		return PendingAction{}, err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return PendingAction{}, err
+	}
+	now := time.Now().UTC()
+	p := PendingAction{
+		Process: process,
+		Action:  action,
+		Author:  actorFromContext(ctx),
+		When:    now,
+		Expires: now.Add(c.window),
+		Token:   hex.EncodeToString(buf),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for t, x := range c.pending {
+		if now.After(x.Expires) {
+			delete(c.pending, t)
+		}
+	}
+	c.pending[p.Token] = p
+	return p, nil
+}
+
+// Confirm runs the action requested with token and checks the state of the
+// process afterwards. A token can only be used once, by the user who
+// requested the action. The action is given its own deadline, independent of
+// the one of ctx, so that it is not cut before its wait elapses.
+func (c *Controller) Confirm(ctx context.Context, process, action, token string) (ControlResult, error) {
+	m, a, err := c.lookup(process, action)
+	if err != nil {
+		return ControlResult{}, err
+	}
+	if err := c.take(ctx, process, action, token); err != nil {
+		return ControlResult{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.deadline())
+	defer cancel()
+	return run(ctx, m, a)
+}
+
+func (c *Controller) take(ctx context.Context, process, action, token string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		p  PendingAction
+		ok bool
+	)
+	for t, x := range c.pending {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			p, ok = x, true
+			break
+		}
+	}
+	if !ok || p.Process != process || p.Action != action {
+		return fmt.Errorf("%w: invalid token for %s of %s", ErrForbidden, action, process)
+	}
+	if actorFromContext(ctx) != p.Author {
+		return fmt.Errorf("%w: %s of %s should be confirmed by %s", ErrForbidden, action, process, p.Author)
+	}
+	delete(c.pending, p.Token)
+	if time.Now().After(p.Expires) {
+		return fmt.Errorf("%w: token for %s of %s expired", ErrForbidden, action, process)
+	}
+	return nil
+}
+
+func run(ctx context.Context, m Monitor, a ControlAction) (ControlResult, error) {
+	res := ControlResult{
+		Process: m.Name,
+		Action:  a.Name,
+		Expect:  a.expected(),
+		When:    time.Now().UTC(),
+		Before:  m.readProcess(),
+	}
+	if a.Signal != "" {
+		if err := sendSignal(res.Before, a.Signal); err != nil {
+			return res, err
+		}
+	} else {
+		cmd := exec.CommandContext(ctx, a.Command[0], a.Command[1:]...)
+		out, err := cmd.CombinedOutput()
+		if len(out) > maxControlOutput {
+			out = out[len(out)-maxControlOutput:]
+		}
+		res.Output = strings.TrimSpace(string(out))
+		if err != nil {
+			res.Reason = fmt.Sprintf("command failed: %s", err)
+			res.After = m.readProcess()
+			return res, ControlError{Result: res, Err: err}
+		}
+	}
+	res.After, res.Done = waitState(ctx, m, res.Expect, res.Before, seconds(a.Wait, DefaultControlWait))
+	if !res.Done {
+		res.Reason = fmt.Sprintf("process not %s after action", res.Expect)
+	}
+	return res, nil
+}
+
+func sendSignal(status map[string]string, name string) error {
+	if !isRunning(status) {
+		return fmt.Errorf("%w: process not running or pidfile stale", ErrConflict)
+	}
+	sig, err := parseSignal(name)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(status["pid"])
+	if err != nil {
+		return fmt.Errorf("%w: invalid pid %s", ErrConflict, status["pid"])
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return proc.Signal(sig)
+}
+
+// waitState reads the status of the process until it is in the expected
+// state or wait elapses.
+func waitState(ctx context.Context, m Monitor, expect string, before map[string]string, wait time.Duration) (map[string]string, bool) {
+	var (
+		deadline = time.NewTimer(wait)
+		tick     = time.NewTicker(controlPoll)
+	)
+	defer deadline.Stop()
+	defer tick.Stop()
+	for {
+		after := m.readProcess()
+		if hasState(expect, before, after) {
+			return after, true
+		}
+		select {
+		case <-ctx.Done():
+			return after, false
+		case <-deadline.C:
+			return after, false
+		case <-tick.C:
+		}
+	}
+}
+
+func hasState(expect string, before, after map[string]string) bool {
+	switch expect {
+	case ExpectStopped:
+		return !isRunning(after)
+	case ExpectRestarted:
+		if !isRunning(after) {
+			return false
+		}
+		return after["pid"] != before["pid"] || after["starttime"] != before["starttime"]
+	default:
+		return isRunning(after)
+	}
+}
+
+func isRunning(status map[string]string) bool {
+	pid := status["pid"]
+	return pid != "" && pid != "unknown" && status["stale"] != "true"
+}
+
+func listActions(ctl *Controller) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		return ctl.Actions(mux.Vars(r)[fieldProcess])
+	}
+}
+
+func requestAction(ctl *Controller) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		vars := mux.Vars(r)
+		return ctl.Request(r.Context(), vars[fieldProcess], vars[fieldAction])
+	}
+}
+
+func confirmAction(ctl *Controller) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		c := struct {
+			Token string `json:"token"`
+		}{}
+		if err := parseBody(r, &c); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		vars := mux.Vars(r)
+		return ctl.Confirm(r.Context(), vars[fieldProcess], vars[fieldAction], c.Token)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestControlAction(t *testing.T) {
+	data := []struct {
+		ControlAction
+		Valid bool
+	}{
+		{ControlAction: ControlAction{Name: "reload", Signal: "SIGHUP"}, Valid: true},
+		{ControlAction: ControlAction{Name: "stop", Signal: "term", Expect: ExpectStopped}, Valid: true},
+		{ControlAction: ControlAction{Name: "start", Command: []string{"systemctl", "start", "autobrm"}, Expect: ExpectRunning}, Valid: true},
+		{ControlAction: ControlAction{Name: "start", Command: []string{"systemctl", "start", "autobrm"}}},
+		{ControlAction: ControlAction{Signal: "HUP"}},
+		{ControlAction: ControlAction{Name: "reload"}},
+		{ControlAction: ControlAction{Name: "reload", Signal: "HUP", Command: []string{"true"}}},
+		{ControlAction: ControlAction{Name: "reload", Signal: "SIGFOO"}},
+		{ControlAction: ControlAction{Name: "reload", Signal: "HUP", Expect: "sleeping"}},
+	}
+	for _, d := range data {
+		err := d.check()
+		if d.Valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+		}
+		if !d.Valid && err == nil {
+			t.Errorf("%s: expected error", d.Name)
+		}
+	}
+}
+
+func TestController(t *testing.T) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var (
+		dir  = t.TempDir()
+		self = strconv.Itoa(os.Getpid())
+		pid  = filepath.Join(dir, "autobrm.pid")
+	)
+	files := map[string]string{
+		"saved.pid":       self + "\n",
+		self + "/cmdline": "autobrm\x00",
+		self + "/status":  "Name:\tautobrm\nState:\tS (sleeping)\nPid:\t" + self + "\n",
+	}
+	for f, c := range files {
+		file := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mon := Monitor{
+		Name: DefaultProcess,
+		Pid:  pid,
+		Proc: dir,
+		Actions: []ControlAction{
+			{Name: "start", Command: []string{"cp", filepath.Join(dir, "saved.pid"), pid}, Expect: ExpectRunning, Wait: 1},
+			{Name: "fail", Command: []string{"sh", "-c", "echo broken; exit 1"}, Expect: ExpectRunning, Wait: 1},
+			{Name: "reload", Signal: "HUP", Wait: 1},
+			{Name: "stop", Signal: "HUP", Expect: ExpectStopped, Wait: 1},
+		},
+	}
+	ctl, err := NewController([]Monitor{mon}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		ctx   = context.Background()
+		other = context.WithValue(ctx, userKey{}, User{Name: "other"})
+	)
+	if _, err := ctl.Request(ctx, DefaultProcess, "restart"); err == nil {
+		t.Fatalf("unknown action requested")
+	}
+	if _, err := ctl.Request(ctx, "archiver", "start"); err == nil {
+		t.Fatalf("action requested for unknown process")
+	}
+	if _, err := ctl.Confirm(ctx, DefaultProcess, "reload", ""); err == nil {
+		t.Fatalf("action confirmed without token")
+	}
+	if p, _ := ctl.Request(ctx, DefaultProcess, "reload"); p.Token != "" {
+		if _, err := ctl.Confirm(ctx, DefaultProcess, "reload", p.Token); err == nil {
+			t.Fatalf("reload of stopped process should fail")
+		}
+	}
+
+	p, err := ctl.Request(ctx, DefaultProcess, "start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctl.Confirm(other, DefaultProcess, "start", p.Token); err == nil {
+		t.Fatalf("action confirmed by another user")
+	}
+	p, _ = ctl.Request(ctx, DefaultProcess, "start")
+	if _, err := ctl.Confirm(ctx, DefaultProcess, "reload", p.Token); err == nil {
+		t.Fatalf("token used for another action")
+	}
+	p, _ = ctl.Request(ctx, DefaultProcess, "start")
+	res, err := ctl.Confirm(ctx, DefaultProcess, "start", p.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Done || res.Before["pid"] != "unknown" || res.After["pid"] != self {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if _, err := ctl.Confirm(ctx, DefaultProcess, "start", p.Token); err == nil {
+		t.Fatalf("token used twice")
+	}
+
+	p, _ = ctl.Request(ctx, DefaultProcess, "reload")
+	if res, err = ctl.Confirm(ctx, DefaultProcess, "reload", p.Token); err != nil || !res.Done {
+		t.Fatalf("unexpected result: %+v (%v)", res, err)
+	}
+	select {
+	case <-hup:
+	case <-time.After(time.Second):
+		t.Fatalf("signal not received")
+	}
+
+	p, _ = ctl.Request(ctx, DefaultProcess, "stop")
+	if res, err = ctl.Confirm(ctx, DefaultProcess, "stop", p.Token); err != nil || res.Done || res.Reason == "" {
+		t.Fatalf("unexpected result: %+v (%v)", res, err)
+	}
+
+	p, _ = ctl.Request(ctx, DefaultProcess, "fail")
+	_, err = ctl.Confirm(ctx, DefaultProcess, "fail", p.Token)
+	var ce ControlError
+	if !errors.As(err, &ce) {
+		t.Fatalf("failed command not reported: %v", err)
+	}
+	if ce.Result.Done || ce.Result.Output != "broken" {
+		t.Fatalf("unexpected result: %+v", ce.Result)
+	}
+}
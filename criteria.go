@@ -21,6 +21,7 @@ const (
 	fieldCompleted = "completed"
 	fieldOrder     = "order"
 	fieldBy        = "by"
+	fieldOverlap   = "overlap"
 )
 
 type Criteria struct {
@@ -111,7 +112,7 @@ func (c Criteria) filterReplay() quel.SQLer {
 	where := c.filterDates("r")
 	if c.Status != "" {
 		eq := quel.Equal(quel.NewIdent("status", "r"), quel.Arg("status", c.Status))
-		if where != nil {
+		if where == nil {
 			where = eq
 		} else {
 			where = quel.And(where, eq)
@@ -120,6 +121,27 @@ func (c Criteria) filterReplay() quel.SQLer {
 	return where
 }
 
+func (c Criteria) filterAudit() quel.SQLer {
+	return c.filterDates("a")
+}
+
+func (c Criteria) filterAlerts() quel.SQLer {
+	where := c.filterDates("a")
+	if c.Status != "" {
+		eq := quel.Equal(quel.NewIdent("state", "a"), quel.Arg("state", c.Status))
+		if where == nil {
+			where = eq
+		} else {
+			where = quel.And(where, eq)
+		}
+	}
+	return where
+}
+
+func (c Criteria) filterNotifyFailures() quel.SQLer {
+	return c.filterDates("n")
+}
+
 func (c Criteria) filterDates(alias string) quel.SQLer {
 	var where quel.SQLer
 	if c.Starts.IsZero() && !c.Ends.IsZero() {
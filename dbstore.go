@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -12,52 +14,108 @@ import (
 
 const DefaultOrderField = "timestamp"
 
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite"
+)
+
 type DBStore struct {
-	db  *sql.DB
-	mon Monitor
+	db      *sql.DB
+	driver  string
+	mon     Monitor
+	procs   []Monitor
+	samples map[string]*sampleRing
+}
+
+// NewDBStore gives a store using a MySQL database. The first monitor given is
+// the one of autobrm.
+func NewDBStore(addr, name, user, passwd string, mons []Monitor) (Store, error) {
+	db, err := openMySQL(addr, name, user, passwd)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSchema(context.Background(), db, DriverMySQL); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := DBStore{
+		db:      db,
+		driver:  DriverMySQL,
+		mon:     mons[0],
+		procs:   mons,
+		samples: newSampleRings(mons),
+	}
+	return s, nil
 }
 
-func NewDBStore(addr, name, user, passwd string, mon Monitor) (Store, error) {
+func openMySQL(addr, name, user, passwd string) (*sql.DB, error) {
 	addr = fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true", user, passwd, addr, name)
-	db, err := sql.Open("mysql", addr)
+	db, err := sql.Open(DriverMySQL, addr)
 	if err != nil {
 		return nil, fmt.Errorf("fail to connect: %w", err)
 	}
 	db.SetConnMaxLifetime(time.Second * 150)
 	db.SetMaxOpenConns(10)
 	db.SetMaxIdleConns(10)
+	return db, nil
+}
 
-	s := DBStore{
-		db:  db,
-		mon: mon,
-	}
-	return s, nil
+// Ping checks that the database can be reached.
+func (s DBStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
 }
 
-func (s DBStore) Status() (interface{}, error) {
-	where := quel.Equal(quel.NewIdent("timestamp"), quel.Func("current_date"))
+// CheckSchema checks that the schema of the database is the one expected.
+func (s DBStore) CheckSchema(ctx context.Context) error {
+	return checkSchema(ctx, s.db, s.driver)
+}
+
+// Stats gives the statistics of the pool of connections to the database.
+func (s DBStore) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+func (s DBStore) Status(ctx context.Context) (interface{}, error) {
+	where := quel.Equal(quel.NewIdent("timestamp"), s.today())
+	requests, err := s.countRequests(ctx, where)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := s.FetchPendingDuration(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hrd, err := s.countGapsHRD(ctx, where)
+	if err != nil {
+		return nil, err
+	}
+	vmu, err := s.countGapsVMU(ctx, where)
+	if err != nil {
+		return nil, err
+	}
 	status := map[string]interface{}{
-		"autobrm": s.mon.readProcess(),
+		"autobrm":   s.mon.readProcess(),
+		"processes": readProcesses(s.procs),
 		"requests": map[string]interface{}{
-			"count": s.countRequests(where),
-			"duration": s.pendingTime(),
+			"count":    requests,
+			"duration": duration,
 		},
 		"hrd": map[string]interface{}{
-			"count": s.countGapsHRD(where),
+			"count": hrd,
 		},
 		"vmu": map[string]interface{}{
-			"count": s.countGapsVMU(where),
+			"count": vmu,
 		},
 	}
 	return status, nil
 }
 
-func (s DBStore) FetchStatusHRD(days int) ([]PacketInfo, error) {
+func (s DBStore) FetchStatusHRD(ctx context.Context, days int) ([]PacketInfo, error) {
 	if days <= 0 {
 		days = 30
 	}
 	var (
-		expr    = quel.Func("DATE_SUB", quel.NewIdent("CURRENT_DATE"), quel.Days(days))
+		expr    = s.daysBack(days)
 		options = []quel.SelectOption{
 			quel.SelectColumn(quel.NewIdent("label")),
 			quel.SelectColumn(quel.NewIdent("timestamp")),
@@ -71,24 +129,24 @@ func (s DBStore) FetchStatusHRD(days int) ([]PacketInfo, error) {
 		return nil, err
 	}
 	var vs []PacketInfo
-	return vs, s.query(q, func(rows *sql.Rows) error {
+	return vs, s.query(ctx, q, func(rows *sql.Rows) error {
 		var (
 			i   PacketInfo
 			err error
 		)
-		if err = rows.Scan(&i.Label, &i.When, &i.Channel, &i.Count); err == nil {
+		if err = rows.Scan(&i.Label, scanTime{&i.When}, &i.Channel, &i.Count); err == nil {
 			vs = append(vs, i)
 		}
 		return err
 	})
 }
 
-func (s DBStore) FetchCounts(days int) ([]ItemInfo, error) {
+func (s DBStore) FetchCounts(ctx context.Context, days int) ([]ItemInfo, error) {
 	if days <= 0 {
 		days = 30
 	}
 	var (
-		expr    = quel.Func("DATE_SUB", quel.NewIdent("CURRENT_DATE"), quel.Days(days))
+		expr    = s.daysBack(days)
 		options = []quel.SelectOption{
 			quel.SelectColumn(quel.NewIdent("label")),
 			quel.SelectColumn(quel.NewIdent("origin")),
@@ -103,26 +161,26 @@ func (s DBStore) FetchCounts(days int) ([]ItemInfo, error) {
 		return nil, err
 	}
 	var vs []ItemInfo
-	return vs, s.query(q, func(rows *sql.Rows) error {
+	return vs, s.query(ctx, q, func(rows *sql.Rows) error {
 		var (
 			i   ItemInfo
 			err error
 		)
-		if err = rows.Scan(&i.Label, &i.Origin, &i.When, &i.Count, &i.Duration); err == nil {
+		if err = rows.Scan(&i.Label, &i.Origin, scanTime{&i.When}, &i.Count, &i.Duration); err == nil {
 			vs = append(vs, i)
 		}
 		return err
 	})
 }
 
-func (s DBStore) FetchStatus() ([]StatusInfo, error) {
+func (s DBStore) FetchStatus(ctx context.Context) ([]StatusInfo, error) {
 	q, err := prepareStatusInfoQuery()
 	if err != nil {
 		return nil, err
 	}
 
 	var vs []StatusInfo
-	return vs, s.query(q, func(rows *sql.Rows) error {
+	return vs, s.query(ctx, q, func(rows *sql.Rows) error {
 		var (
 			s   StatusInfo
 			err error
@@ -134,12 +192,12 @@ func (s DBStore) FetchStatus() ([]StatusInfo, error) {
 	})
 }
 
-func (s DBStore) FetchReplayStats(days int) ([]JobStatus, error) {
+func (s DBStore) FetchReplayStats(ctx context.Context, days int) ([]JobStatus, error) {
 	if days <= 0 {
 		days = 30
 	}
 	var (
-		expr    = quel.Func("DATE_SUB", quel.NewIdent("CURRENT_DATE"), quel.Days(days))
+		expr    = s.daysBack(days)
 		options = []quel.SelectOption{
 			quel.SelectColumns("label", "timestamp", "count"),
 			quel.SelectWhere(quel.GreaterOrEqual(quel.NewIdent("timestamp"), expr)),
@@ -150,76 +208,85 @@ func (s DBStore) FetchReplayStats(days int) ([]JobStatus, error) {
 		return nil, err
 	}
 	var vs []JobStatus
-	return vs, s.query(q, func(rows *sql.Rows) error {
+	return vs, s.query(ctx, q, func(rows *sql.Rows) error {
 		var (
 			j   JobStatus
 			err error
 		)
-		if err = rows.Scan(&j.Status, &j.When, &j.Count); err == nil {
+		if err = rows.Scan(&j.Status, scanTime{&j.When}, &j.Count); err == nil {
 			vs = append(vs, j)
 		}
 		return err
 	})
 }
 
-func (s DBStore) FetchReplays(query Criteria) (int, []Replay, error) {
-	var (
-		where = query.filterReplay()
-		count = s.countItems("replay_list", "r", where)
-	)
-	q, err := prepareSelectReplay(where, query.orderAndLimits())
+func (s DBStore) FetchReplays(ctx context.Context, query Criteria) (int, []Replay, error) {
+	where := query.filterReplay()
+	count, err := s.countItems(ctx, "replay_list", "r", where)
 	if err != nil {
 		return 0, nil, err
 	}
-	var vs []Replay
-	return count, vs, s.query(q, func(rows *sql.Rows) error {
-		var (
-			r   Replay
-			err error
-		)
-		if err = rows.Scan(&r.Id, &r.When, &r.Starts, &r.Ends, &r.Priority, &r.Comment, &r.Status, &r.Automatic, &r.Cancellable, &r.Corrupted, &r.Missing); err == nil {
-			vs = append(vs, r)
-		}
-		r.When = r.When.UTC()
-		r.Starts = r.Starts.UTC()
-		r.Ends = r.Ends.UTC()
-		return err
-	})
+	q, err := prepareSelectReplay("replay_list", where, query.orderAndLimits())
+	if err != nil {
+		return 0, nil, err
+	}
+	vs, err := s.queryReplays(ctx, q)
+	return count, vs, err
 }
 
-func (s DBStore) FetchReplayDetail(id int) (Replay, error) {
-	var r Replay
-	return r, ErrImpl
-}
+func (s DBStore) FetchReplayDetail(ctx context.Context, id int) (ReplayDetail, error) {
+	var r ReplayDetail
+	if err := s.retrReplay(ctx, id, &r.Replay); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("%w: replay %d not found", ErrExist, id)
+		}
+		return r, err
+	}
+	r.When = r.When.UTC()
+	r.Starts = r.Starts.UTC()
+	r.Ends = r.Ends.UTC()
 
-func (s DBStore) CancelReplay(id int, comment string) (Replay, error) {
-	var r Replay
-	if err := s.shouldCancelReplay(id); err != nil {
+	var err error
+	if r.Jobs, err = s.fetchReplayJobs(ctx, id); err != nil {
 		return r, err
 	}
-	get, err := prepareRetrCancelStatus("id")
+	r.computeDurations(time.Now().UTC())
+
+	// the gaps of the replay are given whatever their age
+	q, err := prepareSelectGapsHRD("hrd_gap_replay", quel.Equal(quel.NewIdent("replay", "r"), quel.Arg("replay", id)), nil)
 	if err != nil {
 		return r, err
 	}
-	tx, err := s.db.Begin()
-	options := []quel.InsertOption{
-		quel.InsertColumns("timestamp", "replay_id", "replay_status_id", "text"),
-		quel.InsertValues(quel.Now(), quel.Arg("id", id), get, quel.Arg("comment", comment)),
+	if r.HRD, err = s.queryGapsHRD(ctx, q); err != nil {
+		return r, err
 	}
-	i, err := quel.NewInsert("replay_job", options...)
-	if err == nil {
-		err = s.exec(tx, i, []string{"id", "comment"})
+	q, err = prepareSelectGapsVMU("vmu_gap_replay", quel.Equal(quel.NewIdent("replay", "g"), quel.Arg("replay", id)), nil)
+	if err != nil {
+		return r, err
+	}
+	r.VMU, err = s.queryGapsVMU(ctx, q)
+	return r, err
+}
+
+func (s DBStore) CancelReplay(ctx context.Context, id int, comment string) (Replay, error) {
+	var r Replay
+	if err := s.shouldCancelReplay(ctx, id); err != nil {
+		return r, err
 	}
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
+		return r, err
+	}
+	if err := s.cancelReplay(ctx, tx, id, comment); err != nil {
 		tx.Rollback()
 		return r, err
 	} else {
 		tx.Commit()
 	}
-	return r, s.retrReplay(id, &r)
+	return r, s.retrReplay(ctx, id, &r)
 }
 
-func (s DBStore) UpdateReplay(id int, priority int) (Replay, error) {
+func (s DBStore) UpdateReplay(ctx context.Context, id int, priority int) (Replay, error) {
 	var (
 		r       Replay
 		options = []quel.UpdateOption{
@@ -231,40 +298,57 @@ func (s DBStore) UpdateReplay(id int, priority int) (Replay, error) {
 	if err != nil {
 		return r, err
 	}
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return r, err
 	}
-	if err = s.exec(tx, q, []string{"priority", "id"}); err != nil {
+	if err = s.exec(ctx, tx, q, []string{"priority", "id"}); err != nil {
 		tx.Rollback()
 		return r, err
 	}
 	if err = tx.Commit(); err != nil {
 		return r, err
 	}
-	return r, s.retrReplay(id, &r)
+	return r, s.retrReplay(ctx, id, &r)
 }
 
-func (s DBStore) RegisterReplay(r Replay) (Replay, error) {
-	if !r.isValid() {
-		return r, fmt.Errorf("%w: invalid period", ErrQuery)
-	}
-	tx, err := s.db.Begin()
+func (s DBStore) RegisterReplay(ctx context.Context, r Replay, mode Overlap) ([]Replay, error) {
+	return s.RegisterReplayGaps(ctx, r, mode, []GapGroup{{Period: r.Period}})
+}
+
+// RegisterReplayGaps registers a replay for each group with the priority and
+// comment of r. The replays are registered in the same transaction: none of
+// them is registered if one of them can not be.
+func (s DBStore) RegisterReplayGaps(ctx context.Context, r Replay, mode Overlap, gs []GapGroup) ([]Replay, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return r, err
+		return nil, err
 	}
-	if err := s.registerReplay(tx, &r); err != nil {
-		tx.Rollback()
-		return r, err
+	var rs []Replay
+	for _, g := range gs {
+		r.Period = g.Period
+		xs, err := s.registerReplayGroup(ctx, tx, r, mode, g)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		rs = append(rs, xs...)
 	}
-	if err := s.registerReplayJob(tx, &r); err != nil {
-		tx.Rollback()
-		return r, err
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for i := range rs {
+		if err := s.retrReplay(ctx, rs[i].Id, &rs[i]); err != nil {
+			return nil, err
+		}
+		rs[i].When = rs[i].When.UTC()
+		rs[i].Starts = rs[i].Starts.UTC()
+		rs[i].Ends = rs[i].Ends.UTC()
 	}
-	return r, tx.Commit()
+	return rs, nil
 }
 
-func (s DBStore) FetchChannels() ([]ChannelInfo, error) {
+func (s DBStore) FetchChannels(ctx context.Context) ([]ChannelInfo, error) {
 	options := []quel.SelectOption{
 		quel.SelectColumn(quel.NewIdent("channel")),
 		quel.SelectColumn(quel.NewIdent("total")),
@@ -274,7 +358,7 @@ func (s DBStore) FetchChannels() ([]ChannelInfo, error) {
 		return nil, err
 	}
 	var vs []ChannelInfo
-	return vs, s.query(q, func(rows *sql.Rows) error {
+	return vs, s.query(ctx, q, func(rows *sql.Rows) error {
 		var (
 			c   ChannelInfo
 			err error
@@ -286,69 +370,108 @@ func (s DBStore) FetchChannels() ([]ChannelInfo, error) {
 	})
 }
 
-func (s DBStore) FetchGapsHRD(query Criteria) (int, []HRDGap, error) {
-	var (
-		where = query.filterHRD()
-		count = s.countItems("hrd_gap_list", "r", where)
-	)
-	q, err := prepareSelectGapsHRD(where, query.orderAndLimits())
+func (s DBStore) FetchGapsHRD(ctx context.Context, query Criteria) (int, []HRDGap, error) {
+	where := query.filterHRD()
+	count, err := s.countItems(ctx, "hrd_gap_list", "r", where)
 	if err != nil {
 		return 0, nil, err
 	}
-	var vs []HRDGap
-	return count, vs, s.query(q, func(rows *sql.Rows) error {
-		var (
-			g   HRDGap
-			err error
-		)
-		if err = rows.Scan(&g.Id, &g.When, &g.Starts, &g.First, &g.Ends, &g.Last, &g.Channel, &g.Replay, &g.Completed); err == nil {
-			vs = append(vs, g)
-		}
-		g.When = g.When.UTC()
-		g.Starts = g.Starts.UTC()
-		g.Ends = g.Ends.UTC()
-		return err
-	})
+	q, err := prepareSelectGapsHRD("hrd_gap_list", where, query.orderAndLimits())
+	if err != nil {
+		return 0, nil, err
+	}
+	vs, err := s.queryGapsHRD(ctx, q)
+	return count, vs, err
 }
 
-func (s DBStore) FetchGapDetailHRD(id int) (HRDGap, error) {
-	var h HRDGap
-	return h, ErrImpl
+// FindGapsHRD gives the gaps matching query whatever their age and whether
+// they are linked to a replay or not.
+func (s DBStore) FindGapsHRD(ctx context.Context, query Criteria) ([]HRDGap, error) {
+	q, err := prepareSelectGapsHRD("hrd_gap_detail", query.filterHRD(), query.orderAndLimits())
+	if err != nil {
+		return nil, err
+	}
+	return s.queryGapsHRD(ctx, q)
 }
 
-func (s DBStore) FetchGapsVMU(query Criteria) (int, []VMUGap, error) {
-	var (
-		where = query.filterVMU()
-		count = s.countItems("vmu_gap_list", "g", where)
-	)
+func (s DBStore) FetchGapDetailHRD(ctx context.Context, id int) (HRDGapDetail, error) {
+	var h HRDGapDetail
+	q, err := prepareSelectGapsHRD("hrd_gap_detail", quel.Equal(quel.NewIdent("id", "r"), quel.Arg("id", id)), nil)
+	if err != nil {
+		return h, err
+	}
+	vs, err := s.queryGapsHRD(ctx, q)
+	if err != nil {
+		return h, err
+	}
+	if len(vs) == 0 {
+		return h, fmt.Errorf("%w: hrd gap %d not found", ErrExist, id)
+	}
+	h.HRDGap = vs[0]
+	h.Missing = h.missing()
+	h.Duration = h.duration()
+	if h.Request, err = s.retrGapReplay(ctx, h.Replay); err != nil {
+		return h, err
+	}
+	if h.Previous, err = s.siblingGapHRD(ctx, h.HRDGap, false); err != nil {
+		return h, err
+	}
+	h.Next, err = s.siblingGapHRD(ctx, h.HRDGap, true)
+	return h, err
+}
 
-	q, err := prepareSelectGapsVMU(where, query.orderAndLimits())
+func (s DBStore) FetchGapsVMU(ctx context.Context, query Criteria) (int, []VMUGap, error) {
+	where := query.filterVMU()
+	count, err := s.countItems(ctx, "vmu_gap_list", "g", where)
 	if err != nil {
 		return 0, nil, err
 	}
 
-	var vs []VMUGap
-	return count, vs, s.query(q, func(rows *sql.Rows) error {
-		var (
-			g   VMUGap
-			err error
-		)
-		if err = rows.Scan(&g.Id, &g.When, &g.Starts, &g.First, &g.Ends, &g.Last, &g.Source, &g.UPI, &g.Replay, &g.Completed); err == nil {
-			vs = append(vs, g)
-		}
-		g.When = g.When.UTC()
-		g.Starts = g.Starts.UTC()
-		g.Ends = g.Ends.UTC()
-		return err
-	})
+	q, err := prepareSelectGapsVMU("vmu_gap_list", where, query.orderAndLimits())
+	if err != nil {
+		return 0, nil, err
+	}
+	vs, err := s.queryGapsVMU(ctx, q)
+	return count, vs, err
+}
+
+// FindGapsVMU gives the gaps matching query whatever their age and whether
+// they are linked to a replay or not.
+func (s DBStore) FindGapsVMU(ctx context.Context, query Criteria) ([]VMUGap, error) {
+	q, err := prepareSelectGapsVMU("vmu_gap_detail", query.filterVMU(), query.orderAndLimits())
+	if err != nil {
+		return nil, err
+	}
+	return s.queryGapsVMU(ctx, q)
 }
 
-func (s DBStore) FetchGapDetailVMU(id int) (VMUGap, error) {
-	var v VMUGap
-	return v, ErrImpl
+func (s DBStore) FetchGapDetailVMU(ctx context.Context, id int) (VMUGapDetail, error) {
+	var v VMUGapDetail
+	q, err := prepareSelectGapsVMU("vmu_gap_detail", quel.Equal(quel.NewIdent("id", "g"), quel.Arg("id", id)), nil)
+	if err != nil {
+		return v, err
+	}
+	vs, err := s.queryGapsVMU(ctx, q)
+	if err != nil {
+		return v, err
+	}
+	if len(vs) == 0 {
+		return v, fmt.Errorf("%w: vmu gap %d not found", ErrExist, id)
+	}
+	v.VMUGap = vs[0]
+	v.Missing = v.missing()
+	v.Duration = v.duration()
+	if v.Request, err = s.retrGapReplay(ctx, v.Replay); err != nil {
+		return v, err
+	}
+	if v.Previous, err = s.siblingGapVMU(ctx, v.VMUGap, false); err != nil {
+		return v, err
+	}
+	v.Next, err = s.siblingGapVMU(ctx, v.VMUGap, true)
+	return v, err
 }
 
-func (s DBStore) FetchSources() ([]SourceInfo, error) {
+func (s DBStore) FetchSources(ctx context.Context) ([]SourceInfo, error) {
 	options := []quel.SelectOption{
 		quel.SelectColumn(quel.NewIdent("source")),
 		quel.SelectColumn(quel.NewIdent("total")),
@@ -358,7 +481,7 @@ func (s DBStore) FetchSources() ([]SourceInfo, error) {
 		return nil, err
 	}
 	var rs []SourceInfo
-	return rs, s.query(q, func(rows *sql.Rows) error {
+	return rs, s.query(ctx, q, func(rows *sql.Rows) error {
 		var (
 			r   SourceInfo
 			err error
@@ -370,7 +493,7 @@ func (s DBStore) FetchSources() ([]SourceInfo, error) {
 	})
 }
 
-func (s DBStore) FetchRecords() ([]RecordInfo, error) {
+func (s DBStore) FetchRecords(ctx context.Context) ([]RecordInfo, error) {
 	options := []quel.SelectOption{
 		quel.SelectColumn(quel.NewIdent("phase")),
 		quel.SelectColumn(quel.NewIdent("total")),
@@ -380,7 +503,7 @@ func (s DBStore) FetchRecords() ([]RecordInfo, error) {
 		return nil, err
 	}
 	var rs []RecordInfo
-	return rs, s.query(q, func(rows *sql.Rows) error {
+	return rs, s.query(ctx, q, func(rows *sql.Rows) error {
 		var (
 			r   RecordInfo
 			err error
@@ -392,25 +515,32 @@ func (s DBStore) FetchRecords() ([]RecordInfo, error) {
 	})
 }
 
-func (s DBStore) FetchVariables() ([]Variable, error) {
-	q, err := quel.NewSelect("variable", quel.SelectColumns("id", "name", "value"))
+func (s DBStore) FetchVariables(ctx context.Context) ([]Variable, error) {
+	q, err := quel.NewSelect("variable", quel.SelectColumns(variableColumns...))
 	if err != nil {
 		return nil, err
 	}
 	var vs []Variable
-	return vs, s.query(q, func(rows *sql.Rows) error {
-		var (
-			v   Variable
-			err error
-		)
-		if err = rows.Scan(&v.Id, &v.Name, &v.Value); err == nil {
-			vs = append(vs, v)
+	return vs, s.query(ctx, q, func(rows *sql.Rows) error {
+		var v Variable
+		if err := scanVariable(rows, &v); err != nil {
+			return err
 		}
-		return err
+		vs = append(vs, v)
+		return nil
 	})
 }
 
-func (s DBStore) UpdateVariable(id int, value string) (Variable, error) {
+func (s DBStore) FetchVariable(ctx context.Context, id int) (Variable, error) {
+	var v Variable
+	err := s.retrVariable(ctx, id, &v)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = fmt.Errorf("%w: variable %d not found", ErrExist, id)
+	}
+	return v, err
+}
+
+func (s DBStore) UpdateVariable(ctx context.Context, id int, value string) (Variable, error) {
 	var (
 		v       Variable
 		options = []quel.UpdateOption{
@@ -418,192 +548,1418 @@ func (s DBStore) UpdateVariable(id int, value string) (Variable, error) {
 			quel.UpdateWhere(quel.Equal(quel.NewIdent("id"), quel.Arg("id", id))),
 		}
 	)
+	if err := s.retrVariable(ctx, id, &v); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("%w: variable %d not found", ErrExist, id)
+		}
+		return v, err
+	}
 	q, err := quel.NewUpdate("variable", options...)
 	if err != nil {
 		return v, err
 	}
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return v, err
 	}
-	if err = s.exec(tx, q, []string{"value", "id"}); err != nil {
+	if err = s.exec(ctx, tx, q, []string{"value", "id"}); err != nil {
+		tx.Rollback()
+		return v, err
+	}
+	if err = s.registerVariableVersion(ctx, tx, id, value); err != nil {
 		tx.Rollback()
 		return v, err
 	}
 	if err = tx.Commit(); err != nil {
 		return v, err
 	}
-	return v, s.retrVariable(id, &v)
-}
-
-func (s DBStore) RegisterVariable(v Variable) (Variable, error) {
-	return v, ErrImpl
+	return v, s.retrVariable(ctx, id, &v)
 }
 
-func (s DBStore) exec(tx *sql.Tx, q quel.SQLer, names []string) error {
-	query, args, err := q.SQL()
+func (s DBStore) FetchVariableHistory(ctx context.Context, id int) ([]VariableVersion, error) {
+	var v Variable
+	if err := s.retrVariable(ctx, id, &v); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("%w: variable %d not found", ErrExist, id)
+		}
+		return nil, err
+	}
+	options := []quel.SelectOption{
+		quel.SelectColumns("id", "timestamp", "author", "value"),
+		quel.SelectWhere(quel.Equal(quel.NewIdent("variable_id"), quel.Arg("id", id))),
+		quel.SelectOrderBy(quel.Desc("id")),
+	}
+	q, err := quel.NewSelect("variable_history", options...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = tx.Exec(query, args...)
-	return err
+	var vs []VariableVersion
+	return vs, s.query(ctx, q, func(rows *sql.Rows) error {
+		var (
+			v   VariableVersion
+			err error
+		)
+		if err = rows.Scan(&v.Version, &v.When, &v.Author, &v.Value); err == nil {
+			v.When = v.When.UTC()
+			vs = append(vs, v)
+		}
+		return err
+	})
 }
 
-func (s DBStore) query(q quel.SQLer, scan func(rows *sql.Rows) error) error {
-	query, args, err := q.SQL()
+func (s DBStore) RegisterVariable(ctx context.Context, v Variable) (Variable, error) {
+	var (
+		min, max, allowed = v.rangeValues()
+		options           = []quel.InsertOption{
+			quel.InsertColumns(variableColumns[1:]...),
+			quel.InsertValues(
+				quel.Arg("name", v.Name),
+				quel.Arg("value", v.Value),
+				quel.Arg("type", v.Type),
+				quel.Arg("min", nullString(min)),
+				quel.Arg("max", nullString(max)),
+				quel.Arg("allowed", nullString(allowed)),
+				quel.Arg("hazardous", v.Hazardous),
+			),
+		}
+	)
+	q, err := quel.NewInsert("variable", options...)
 	if err != nil {
-		return err
+		return v, err
 	}
-	fmt.Println(query, args)
-	rows, err := s.db.Query(query, args...)
-	switch err {
-	case nil:
-	case sql.ErrNoRows:
-		return ErrEmpty
-	default:
-		return err
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return v, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		if err := scan(rows); err != nil {
-			return err
-		}
+	if err = s.checkVariableName(ctx, tx, v.Name); err != nil {
+		tx.Rollback()
+		return v, err
 	}
-	return nil
+	if v.Id, err = s.insert(ctx, tx, q); err != nil {
+		tx.Rollback()
+		return v, err
+	}
+	if err = s.registerVariableVersion(ctx, tx, v.Id, v.Value); err != nil {
+		tx.Rollback()
+		return v, err
+	}
+	if err = tx.Commit(); err != nil {
+		return v, err
+	}
+	return s.FetchVariable(ctx, v.Id)
 }
 
-func (s DBStore) shouldCancelReplay(id int) error {
-	sub, err := prepareRetrCancelStatus("id")
+func (s DBStore) DeleteVariable(ctx context.Context, id int) (Variable, error) {
+	v, err := s.FetchVariable(ctx, id)
 	if err != nil {
-		return err
-	}
-	var (
-		ideq  = quel.Equal(quel.NewIdent("replay_id"), quel.Arg("id", id))
-		jobeq = quel.Equal(quel.NewIdent("replay_status_id"), sub)
-		and   = quel.And(ideq, jobeq)
-	)
-	options := []quel.SelectOption{
-		quel.SelectColumn(quel.NewIdent("replay_id")),
-		quel.SelectWhere(and),
+		return v, err
 	}
-	q, err := quel.NewSelect("replay_job", options...)
+	q, err := quel.NewDelete("variable", quel.DeleteWhere(quel.Equal(quel.NewIdent("id"), quel.Arg("id", id))))
 	if err != nil {
-		return err
+		return v, err
 	}
-	query, args, err := q.SQL()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return v, err
 	}
-	err = s.db.QueryRow(query, args...).Scan(&id)
-	if err == nil {
-		err = fmt.Errorf("%w: replay job already cancelled", ErrQuery)
-	} else if errors.Is(err, sql.ErrNoRows) {
-		err = nil
+	if err = s.exec(ctx, tx, q, []string{"id"}); err != nil {
+		tx.Rollback()
+		return v, err
 	}
-	return err
+	return v, tx.Commit()
 }
 
-func (s DBStore) retrVariable(id int, v *Variable) error {
+func (s DBStore) FetchPendingChanges(ctx context.Context) ([]PendingChange, error) {
 	options := []quel.SelectOption{
-		quel.SelectColumns("id", "name", "value"),
-		quel.SelectWhere(quel.Equal(quel.NewIdent("id"), quel.Arg("id", id))),
-	}
-	q, err := quel.NewSelect("variable", options...)
-	if err != nil {
-		return err
+		quel.SelectColumns(pendingColumns...),
+		quel.SelectWhere(quel.Equal(quel.NewIdent("status"), quel.Arg("status", ChangePending))),
+		quel.SelectOrderBy(quel.Asc("id")),
 	}
-	query, args, err := q.SQL()
+	q, err := quel.NewSelect("variable_pending", options...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return s.db.QueryRow(query, args...).Scan(&v.Id, &v.Name, &v.Value)
+	var ps []PendingChange
+	return ps, s.query(ctx, q, func(rows *sql.Rows) error {
+		var p PendingChange
+		if err := scanPendingChange(rows, &p); err != nil {
+			return err
+		}
+		ps = append(ps, p)
+		return nil
+	})
 }
 
-func (s DBStore) retrReplay(id int, r *Replay) error {
-	where := quel.Equal(quel.NewIdent("id", "r"), quel.Arg("id", id))
-	q, err := prepareSelectReplay(where, nil)
+func (s DBStore) FetchPendingChange(ctx context.Context, id int) (PendingChange, error) {
+	var p PendingChange
+	q, err := prepareSelectPendingChange(id)
 	if err != nil {
-		return err
+		return p, err
 	}
 	query, args, err := q.SQL()
 	if err != nil {
-		return err
+		return p, err
 	}
-	return s.db.QueryRow(query, args...).Scan(&r.Id, &r.When, &r.Starts, &r.Ends, &r.Priority, &r.Comment, &r.Status, &r.Automatic, &r.Cancellable, &r.Corrupted, &r.Missing)
+	err = scanPendingChange(s.db.QueryRowContext(ctx, query, args...), &p)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = fmt.Errorf("%w: pending change %d not found", ErrExist, id)
+	}
+	return p, err
 }
 
-func (s DBStore) registerReplay(tx *sql.Tx, r *Replay) error {
-	insert := []quel.InsertOption{
-		quel.InsertColumns("timestamp", "startdate", "enddate", "priority"),
-		quel.InsertValues(quel.Now(), quel.Arg("dtstart", r.Starts), quel.Arg("dtend", r.Ends), quel.Arg("priority", r.Priority)),
+func (s DBStore) RegisterPendingChange(ctx context.Context, p PendingChange) (PendingChange, error) {
+	if _, err := s.FetchVariable(ctx, p.Variable); err != nil {
+		return p, err
 	}
-	i, err := quel.NewInsert("replay", insert...)
+	options := []quel.InsertOption{
+		quel.InsertColumns("variable_id", "timestamp", "expires", "author", "value", "token"),
+		quel.InsertValues(
+			quel.Arg("variable", p.Variable),
+			quel.Arg("timestamp", p.When),
+			quel.Arg("expires", p.Expires),
+			quel.Arg("author", p.Author),
+			quel.Arg("value", p.Value),
+			quel.Arg("token", p.Token),
+		),
+	}
+	q, err := quel.NewInsert("variable_pending", options...)
 	if err != nil {
-		return err
+		return p, err
 	}
-	if err := s.exec(tx, i, []string{"dtstart", "dtend", "priority"}); err != nil {
-		return err
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return p, err
 	}
-	retrieve := []quel.SelectOption{
-		quel.SelectColumn(quel.Func("LAST_INSERT_ID")),
-		quel.SelectLimit(1),
+	if p.Id, err = s.insert(ctx, tx, q); err != nil {
+		tx.Rollback()
+		return p, err
 	}
-	q, err := quel.NewSelect("replay", retrieve...)
+	return p, tx.Commit()
+}
+
+// ConfirmPendingChange applies the pending change. The change is read and
+// resolved in the same transaction: a change can only be applied once.
+func (s DBStore) ConfirmPendingChange(ctx context.Context, id int) (Variable, error) {
+	var v Variable
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return v, err
+	}
+	p, err := s.retrPendingChange(ctx, tx, id)
+	if err != nil {
+		tx.Rollback()
+		return v, err
+	}
+	if err = s.resolvePendingChange(ctx, tx, id, ChangeConfirmed); err != nil {
+		tx.Rollback()
+		return v, err
+	}
+	options := []quel.UpdateOption{
+		quel.UpdateColumn("value", quel.Arg("value", p.Value)),
+		quel.UpdateWhere(quel.Equal(quel.NewIdent("id"), quel.Arg("id", p.Variable))),
+	}
+	q, err := quel.NewUpdate("variable", options...)
+	if err != nil {
+		tx.Rollback()
+		return v, err
+	}
+	if err = s.exec(ctx, tx, q, []string{"value", "id"}); err != nil {
+		tx.Rollback()
+		return v, err
+	}
+	if err = s.registerVariableVersion(ctx, tx, p.Variable, p.Value); err != nil {
+		tx.Rollback()
+		return v, err
+	}
+	if err = tx.Commit(); err != nil {
+		return v, err
+	}
+	return s.FetchVariable(ctx, p.Variable)
+}
+
+func (s DBStore) DiscardPendingChange(ctx context.Context, id int) (PendingChange, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return PendingChange{}, err
+	}
+	p, err := s.retrPendingChange(ctx, tx, id)
+	if err != nil {
+		tx.Rollback()
+		return p, err
+	}
+	if err = s.resolvePendingChange(ctx, tx, id, ChangeDiscarded); err != nil {
+		tx.Rollback()
+		return p, err
+	}
+	return p, tx.Commit()
+}
+
+func (s DBStore) FetchEventCursor(ctx context.Context) (EventCursor, error) {
+	var (
+		c      EventCursor
+		tables = []struct {
+			Name string
+			Id   *int
+		}{
+			{Name: "replay_job", Id: &c.Job},
+			{Name: "hrd_packet_gap", Id: &c.HRD},
+			{Name: "vmu_packet_gap", Id: &c.VMU},
+			{Name: "variable_history", Id: &c.Variable},
+		}
+		max = quel.Coalesce(quel.Max(quel.NewIdent("id")), quel.Arg("zero", 0))
+	)
+	for _, t := range tables {
+		q, err := quel.NewSelect(t.Name, quel.SelectColumn(max))
+		if err != nil {
+			return c, err
+		}
+		query, args, err := q.SQL()
+		if err != nil {
+			return c, err
+		}
+		if err := s.db.QueryRowContext(ctx, query, args...).Scan(t.Id); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func (s DBStore) FetchEvents(ctx context.Context, c EventCursor) ([]Event, error) {
+	var (
+		es      []Event
+		options = []quel.SelectOption{
+			quel.SelectLimit(MaxEvents),
+			quel.SelectOrderBy(quel.Asc("id")),
+		}
+	)
+	jobs, err := s.fetchJobEvents(ctx, c.Job, options)
+	if err != nil {
+		return nil, err
+	}
+	es = append(es, jobs...)
+
+	q, err := prepareSelectGapsHRD("hrd_gap_event", quel.Greater(quel.NewIdent("id", "r"), quel.Arg("id", c.HRD)), options)
+	if err != nil {
+		return nil, err
+	}
+	hrd, err := s.queryGapsHRD(ctx, q)
+	if err != nil && !errors.Is(err, ErrEmpty) {
+		return nil, err
+	}
+	for _, g := range hrd {
+		es = append(es, Event{Id: g.Id, Type: EventGapHRD, When: g.When, Data: g})
+	}
+
+	q, err = prepareSelectGapsVMU("vmu_gap_event", quel.Greater(quel.NewIdent("id", "g"), quel.Arg("id", c.VMU)), options)
+	if err != nil {
+		return nil, err
+	}
+	vmu, err := s.queryGapsVMU(ctx, q)
+	if err != nil && !errors.Is(err, ErrEmpty) {
+		return nil, err
+	}
+	for _, g := range vmu {
+		es = append(es, Event{Id: g.Id, Type: EventGapVMU, When: g.When, Data: g})
+	}
+
+	vars, err := s.fetchVariableEvents(ctx, c.Variable, options)
+	if err != nil {
+		return nil, err
+	}
+	es = append(es, vars...)
+	return sortEvents(c, es), nil
+}
+
+func (s DBStore) RegisterAudit(ctx context.Context, a Audit) error {
+	var (
+		values = []quel.SQLer{
+			quel.Arg("timestamp", a.When),
+			quel.Arg("actor", a.Actor),
+			quel.Arg("action", a.Action),
+			quel.Arg("target", a.Target),
+			quel.Arg("before", nullText(a.Before)),
+			quel.Arg("after", nullText(a.After)),
+			quel.Arg("origin", a.Origin),
+		}
+		options = []quel.InsertOption{
+			quel.InsertColumns("timestamp", "actor", "action", "target", "before_value", "after_value", "origin"),
+			quel.InsertValues(values...),
+		}
+	)
+	i, err := quel.NewInsert("audit", options...)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := s.exec(ctx, tx, i, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s DBStore) FetchAudits(ctx context.Context, query Criteria) (int, []Audit, error) {
+	where := query.filterAudit()
+	count, err := s.countItems(ctx, "audit", "a", where)
+	if err != nil {
+		return 0, nil, err
+	}
+	options := []quel.SelectOption{
+		quel.SelectAlias("a"),
+		quel.SelectColumns("id", "timestamp", "actor", "action", "target", "before_value", "after_value", "origin"),
+		quel.SelectWhere(where),
+	}
+	options = append(options, query.orderAndLimits()...)
+	q, err := quel.NewSelect("audit", options...)
+	if err != nil {
+		return 0, nil, err
+	}
+	var vs []Audit
+	return count, vs, s.query(ctx, q, func(rows *sql.Rows) error {
+		var (
+			a      Audit
+			before []byte
+			after  []byte
+			err    error
+		)
+		if err = rows.Scan(&a.Id, &a.When, &a.Actor, &a.Action, &a.Target, &before, &after, &a.Origin); err == nil {
+			a.When = a.When.UTC()
+			a.Before = before
+			a.After = after
+			vs = append(vs, a)
+		}
+		return err
+	})
+}
+
+func (s DBStore) RegisterNotifyFailure(ctx context.Context, f NotifyFailure) error {
+	var (
+		values = []quel.SQLer{
+			quel.Arg("timestamp", f.When),
+			quel.Arg("target", f.Target),
+			quel.Arg("type", f.Type),
+			quel.Arg("attempts", f.Attempts),
+			quel.Arg("error", f.Err),
+			quel.Arg("payload", nullText(f.Payload)),
+		}
+		options = []quel.InsertOption{
+			quel.InsertColumns("timestamp", "target", "type", "attempts", "error", "payload"),
+			quel.InsertValues(values...),
+		}
+	)
+	i, err := quel.NewInsert("notification_failure", options...)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := s.exec(ctx, tx, i, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s DBStore) FetchNotifyFailures(ctx context.Context, query Criteria) (int, []NotifyFailure, error) {
+	where := query.filterNotifyFailures()
+	count, err := s.countItems(ctx, "notification_failure", "n", where)
+	if err != nil {
+		return 0, nil, err
+	}
+	options := []quel.SelectOption{
+		quel.SelectAlias("n"),
+		quel.SelectColumns("id", "timestamp", "target", "type", "attempts", "error", "payload"),
+		quel.SelectWhere(where),
+	}
+	options = append(options, query.orderAndLimits()...)
+	q, err := quel.NewSelect("notification_failure", options...)
+	if err != nil {
+		return 0, nil, err
+	}
+	var vs []NotifyFailure
+	return count, vs, s.query(ctx, q, func(rows *sql.Rows) error {
+		var (
+			f       NotifyFailure
+			msg     sql.NullString
+			payload []byte
+			err     error
+		)
+		if err = rows.Scan(&f.Id, &f.When, &f.Target, &f.Type, &f.Attempts, &msg, &payload); err == nil {
+			f.When = f.When.UTC()
+			f.Err = msg.String
+			f.Payload = payload
+			vs = append(vs, f)
+		}
+		return err
+	})
+}
+
+func (s DBStore) FetchPendingDuration(ctx context.Context) (int, error) {
+	q, err := quel.NewSelect("pending_duration", quel.SelectColumn(quel.NewIdent("duration")))
+	if err != nil {
+		return 0, err
+	}
+	query, args, err := q.SQL()
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	if err := s.db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchPendingAge gives the time (in seconds) since the oldest replay still
+// pending has been requested.
+func (s DBStore) FetchPendingAge(ctx context.Context) (int, error) {
+	options := []quel.SelectOption{
+		quel.SelectColumn(quel.NewIdent("timestamp")),
+		quel.SelectWhere(quel.Equal(quel.NewIdent("status"), quel.Arg("status", StatusPending))),
+		quel.SelectOrderBy(quel.Asc("timestamp")),
+		quel.SelectLimit(1),
+	}
+	q, err := quel.NewSelect("replay_detail", options...)
+	if err != nil {
+		return 0, err
+	}
+	var when time.Time
+	err = s.query(ctx, q, func(rows *sql.Rows) error {
+		return rows.Scan(&when)
+	})
+	if err != nil || when.IsZero() {
+		return 0, err
+	}
+	return int(time.Since(when).Seconds()), nil
+}
+
+func (s DBStore) FetchAlerts(ctx context.Context, query Criteria) (int, []Alert, error) {
+	where := query.filterAlerts()
+	count, err := s.countItems(ctx, "alert", "a", where)
+	if err != nil {
+		return 0, nil, err
+	}
+	options := []quel.SelectOption{
+		quel.SelectAlias("a"),
+		quel.SelectColumns("id", "rule", "subject", "state", "value", "text", "timestamp", "resolved"),
+		quel.SelectWhere(where),
+	}
+	options = append(options, query.orderAndLimits()...)
+	q, err := quel.NewSelect("alert", options...)
+	if err != nil {
+		return 0, nil, err
+	}
+	var vs []Alert
+	return count, vs, s.query(ctx, q, func(rows *sql.Rows) error {
+		var (
+			a        Alert
+			resolved sql.NullTime
+			err      error
+		)
+		if err = rows.Scan(&a.Id, &a.Rule, &a.Subject, &a.State, &a.Value, &a.Text, &a.When, &resolved); err == nil {
+			a.When = a.When.UTC()
+			if resolved.Valid {
+				t := resolved.Time.UTC()
+				a.Resolved = &t
+			}
+			vs = append(vs, a)
+		}
+		return err
+	})
+}
+
+func (s DBStore) RegisterAlert(ctx context.Context, a Alert) (Alert, error) {
+	var (
+		values = []quel.SQLer{
+			quel.Arg("rule", a.Rule),
+			quel.Arg("subject", a.Subject),
+			quel.Arg("state", a.State),
+			quel.Arg("value", a.Value),
+			quel.Arg("text", a.Text),
+			quel.Arg("timestamp", a.When),
+		}
+		options = []quel.InsertOption{
+			quel.InsertColumns("rule", "subject", "state", "value", "text", "timestamp"),
+			quel.InsertValues(values...),
+		}
+	)
+	i, err := quel.NewInsert("alert", options...)
+	if err != nil {
+		return a, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return a, err
+	}
+	if a.Id, err = s.insert(ctx, tx, i); err != nil {
+		tx.Rollback()
+		return a, err
+	}
+	return a, tx.Commit()
+}
+
+func (s DBStore) ResolveAlert(ctx context.Context, a Alert) (Alert, error) {
+	if a.Resolved == nil {
+		now := time.Now().UTC()
+		a.Resolved = &now
+	}
+	a.State = AlertResolved
+	options := []quel.UpdateOption{
+		quel.UpdateColumn("state", quel.Arg("state", a.State)),
+		quel.UpdateColumn("resolved", quel.Arg("resolved", *a.Resolved)),
+		quel.UpdateWhere(quel.Equal(quel.NewIdent("id"), quel.Arg("id", a.Id))),
+	}
+	q, err := quel.NewUpdate("alert", options...)
+	if err != nil {
+		return a, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return a, err
+	}
+	if err = s.exec(ctx, tx, q, []string{"state", "resolved", "id"}); err != nil {
+		tx.Rollback()
+		return a, err
+	}
+	return a, tx.Commit()
+}
+
+// RegisterProcessSample keeps s in the history of its process. When the
+// history is persisted, s is saved too and the samples of the process that do
+// not fit anymore in the history are deleted.
+func (s DBStore) RegisterProcessSample(ctx context.Context, p ProcessSample) error {
+	mon, ring, err := s.history(p.Process)
+	if err != nil {
+		return err
+	}
+	oldest, full := ring.add(p)
+	if !mon.History.Persist {
+		return nil
+	}
+	var (
+		values = []quel.SQLer{
+			quel.Arg("process", p.Process),
+			quel.Arg("timestamp", p.When),
+			quel.Arg("pid", p.Pid),
+			quel.Arg("state", p.State),
+			quel.Arg("vmrss", p.RSS),
+			quel.Arg("vmsize", p.VMSize),
+			quel.Arg("cpu", p.CPU),
+		}
+		options = []quel.InsertOption{
+			quel.InsertColumns("process", "timestamp", "pid", "state", "vmrss", "vmsize", "cpu"),
+			quel.InsertValues(values...),
+		}
+	)
+	i, err := quel.NewInsert("process_sample", options...)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := s.exec(ctx, tx, i, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if full {
+		var (
+			proc = quel.Equal(quel.NewIdent("process"), quel.Arg("process", p.Process))
+			when = quel.Lesser(quel.NewIdent("timestamp"), quel.Arg("timestamp", oldest.When))
+		)
+		d, err := quel.NewDelete("process_sample", quel.DeleteWhere(quel.And(proc, when)))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := s.exec(ctx, tx, d, []string{"process", "timestamp"}); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s DBStore) FetchProcessHistory(ctx context.Context, process string, query Criteria) ([]ProcessSample, error) {
+	mon, ring, err := s.history(process)
+	if err != nil {
+		return nil, err
+	}
+	if !mon.History.Persist {
+		return ring.between(query.Starts, query.Ends), nil
+	}
+	where := quel.Equal(quel.NewIdent("process", "p"), quel.Arg("process", process))
+	if dates := query.filterDates("p"); dates != nil {
+		where = quel.And(where, dates)
+	}
+	options := []quel.SelectOption{
+		quel.SelectAlias("p"),
+		quel.SelectColumns("process", "timestamp", "pid", "state", "vmrss", "vmsize", "cpu"),
+		quel.SelectWhere(where),
+		quel.SelectOrderBy(quel.Asc("timestamp")),
+	}
+	q, err := quel.NewSelect("process_sample", options...)
+	if err != nil {
+		return nil, err
+	}
+	var vs []ProcessSample
+	return vs, s.query(ctx, q, func(rows *sql.Rows) error {
+		var (
+			p   ProcessSample
+			err error
+		)
+		if err = rows.Scan(&p.Process, &p.When, &p.Pid, &p.State, &p.RSS, &p.VMSize, &p.CPU); err == nil {
+			p.When = p.When.UTC()
+			vs = append(vs, p)
+		}
+		return err
+	})
+}
+
+// history gives the monitor of the process and the ring keeping its samples.
+func (s DBStore) history(process string) (Monitor, *sampleRing, error) {
+	for _, m := range s.procs {
+		if m.Name == process {
+			return m, s.samples[process], nil
+		}
+	}
+	return Monitor{}, nil, fmt.Errorf("%w: process %s not found", ErrExist, process)
+}
+
+func (s DBStore) exec(ctx context.Context, tx *sql.Tx, q quel.SQLer, names []string) error {
+	query, args, err := q.SQL()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s DBStore) insert(ctx context.Context, tx *sql.Tx, q quel.SQLer) (int, error) {
+	query, args, err := q.SQL()
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func (s DBStore) query(ctx context.Context, q quel.SQLer, scan func(rows *sql.Rows) error) error {
+	return s.queryWith(ctx, s.db, q, scan)
+}
+
+// querier is implemented by sql.DB and sql.Tx to run the same queries in and
+// out of a transaction.
+type querier interface {
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+}
+
+func (s DBStore) queryWith(ctx context.Context, db querier, q quel.SQLer, scan func(rows *sql.Rows) error) error {
+	query, args, err := q.SQL()
+	if err != nil {
+		return err
+	}
+	fmt.Println(query, args)
+	rows, err := db.QueryContext(ctx, query, args...)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return ErrEmpty
+	default:
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s DBStore) queryReplays(ctx context.Context, q quel.SQLer) ([]Replay, error) {
+	return s.queryReplaysWith(ctx, s.db, q)
+}
+
+func (s DBStore) queryReplaysWith(ctx context.Context, db querier, q quel.SQLer) ([]Replay, error) {
+	var vs []Replay
+	return vs, s.queryWith(ctx, db, q, func(rows *sql.Rows) error {
+		var (
+			r   Replay
+			err error
+		)
+		if err = rows.Scan(&r.Id, &r.When, &r.Starts, &r.Ends, &r.Priority, &r.Comment, &r.Status, &r.Automatic, &r.Cancellable, &r.Corrupted, &r.Missing); err == nil {
+			r.When = r.When.UTC()
+			r.Starts = r.Starts.UTC()
+			r.Ends = r.Ends.UTC()
+			vs = append(vs, r)
+		}
+		return err
+	})
+}
+
+func (s DBStore) queryGapsHRD(ctx context.Context, q quel.SQLer) ([]HRDGap, error) {
+	var vs []HRDGap
+	return vs, s.query(ctx, q, func(rows *sql.Rows) error {
+		var (
+			g   HRDGap
+			err error
+		)
+		if err = rows.Scan(&g.Id, &g.When, &g.Starts, &g.First, &g.Ends, &g.Last, &g.Channel, &g.Replay, &g.Completed); err == nil {
+			g.When = g.When.UTC()
+			g.Starts = g.Starts.UTC()
+			g.Ends = g.Ends.UTC()
+			vs = append(vs, g)
+		}
+		return err
+	})
+}
+
+func (s DBStore) queryGapsVMU(ctx context.Context, q quel.SQLer) ([]VMUGap, error) {
+	var vs []VMUGap
+	return vs, s.query(ctx, q, func(rows *sql.Rows) error {
+		var (
+			g   VMUGap
+			err error
+		)
+		if err = rows.Scan(&g.Id, &g.When, &g.Starts, &g.First, &g.Ends, &g.Last, &g.Source, &g.UPI, &g.Replay, &g.Completed); err == nil {
+			g.When = g.When.UTC()
+			g.Starts = g.Starts.UTC()
+			g.Ends = g.Ends.UTC()
+			vs = append(vs, g)
+		}
+		return err
+	})
+}
+
+// siblingGapHRD gives the gap registered just before (or after if next is set)
+// the given gap on the same channel.
+func (s DBStore) siblingGapHRD(ctx context.Context, g HRDGap, next bool) (*HRDGap, error) {
+	where, limits := siblingGap("r", g.Id, next)
+	where = quel.And(where, quel.Equal(quel.NewIdent("channel", "r"), quel.Arg("channel", g.Channel)))
+
+	q, err := prepareSelectGapsHRD("hrd_gap_detail", where, limits)
+	if err != nil {
+		return nil, err
+	}
+	vs, err := s.queryGapsHRD(ctx, q)
+	if err != nil || len(vs) == 0 {
+		return nil, err
+	}
+	return &vs[0], nil
+}
+
+// siblingGapVMU gives the gap registered just before (or after if next is set)
+// the given gap on the same VMU record.
+func (s DBStore) siblingGapVMU(ctx context.Context, g VMUGap, next bool) (*VMUGap, error) {
+	where, limits := siblingGap("g", g.Id, next)
+	where = quel.And(where, quel.Equal(quel.NewIdent("source", "g"), quel.Arg("source", g.Source)))
+	where = quel.And(where, quel.Equal(quel.NewIdent("phase", "g"), quel.Arg("record", g.UPI)))
+
+	q, err := prepareSelectGapsVMU("vmu_gap_detail", where, limits)
+	if err != nil {
+		return nil, err
+	}
+	vs, err := s.queryGapsVMU(ctx, q)
+	if err != nil || len(vs) == 0 {
+		return nil, err
+	}
+	return &vs[0], nil
+}
+
+func (s DBStore) retrGapReplay(ctx context.Context, id int) (*Replay, error) {
+	var r Replay
+	switch err := s.retrReplay(ctx, id, &r); {
+	case err == nil:
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, nil
+	default:
+		return nil, err
+	}
+	r.When = r.When.UTC()
+	r.Starts = r.Starts.UTC()
+	r.Ends = r.Ends.UTC()
+	return &r, nil
+}
+
+func (s DBStore) fetchReplayJobs(ctx context.Context, id int) ([]Job, error) {
+	options := []quel.SelectOption{
+		quel.SelectColumns("timestamp", "status", "workflow", "text"),
+		quel.SelectWhere(quel.Equal(quel.NewIdent("replay"), quel.Arg("replay", id))),
+		quel.SelectOrderBy(quel.Asc("timestamp")),
+	}
+	q, err := quel.NewSelect("replay_job_history", options...)
+	if err != nil {
+		return nil, err
+	}
+	var vs []Job
+	return vs, s.query(ctx, q, func(rows *sql.Rows) error {
+		var (
+			j   Job
+			err error
+		)
+		if err = rows.Scan(&j.When, &j.Status, &j.Order, &j.Text); err == nil {
+			j.When = j.When.UTC()
+			vs = append(vs, j)
+		}
+		return err
+	})
+}
+
+func (s DBStore) fetchJobEvents(ctx context.Context, last int, limits []quel.SelectOption) ([]Event, error) {
+	options := []quel.SelectOption{
+		quel.SelectColumns("id", "replay", "timestamp", "status", "workflow", "text"),
+		quel.SelectWhere(quel.GreaterOrEqual(quel.NewIdent("id"), quel.Arg("id", last+1))),
+	}
+	q, err := quel.NewSelect("replay_job_history", append(options, limits...)...)
+	if err != nil {
+		return nil, err
+	}
+	var es []Event
+	err = s.query(ctx, q, func(rows *sql.Rows) error {
+		var (
+			e   = Event{Type: EventReplay}
+			r   ReplayEvent
+			err error
+		)
+		if err = rows.Scan(&e.Id, &r.Replay, &r.When, &r.Status, &r.Order, &r.Text); err == nil {
+			r.When = r.When.UTC()
+			e.When, e.Data = r.When, r
+			es = append(es, e)
+		}
+		return err
+	})
+	if errors.Is(err, ErrEmpty) {
+		err = nil
+	}
+	return es, err
+}
+
+func (s DBStore) fetchVariableEvents(ctx context.Context, last int, limits []quel.SelectOption) ([]Event, error) {
+	options := []quel.SelectOption{
+		quel.SelectColumns("id", "variable_id", "timestamp", "author", "value"),
+		quel.SelectWhere(quel.GreaterOrEqual(quel.NewIdent("id"), quel.Arg("id", last+1))),
+	}
+	q, err := quel.NewSelect("variable_history", append(options, limits...)...)
+	if err != nil {
+		return nil, err
+	}
+	var es []Event
+	err = s.query(ctx, q, func(rows *sql.Rows) error {
+		var (
+			e   = Event{Type: EventVariable}
+			v   VariableEvent
+			err error
+		)
+		if err = rows.Scan(&v.Version, &v.Variable, &v.When, &v.Author, &v.Value); err == nil {
+			v.When = v.When.UTC()
+			e.Id, e.When, e.Data = v.Version, v.When, v
+			es = append(es, e)
+		}
+		return err
+	})
+	if errors.Is(err, ErrEmpty) {
+		err = nil
+	}
+	return es, err
+}
+
+func (s DBStore) shouldCancelReplay(ctx context.Context, id int) error {
+	sub, err := prepareRetrCancelStatus("id")
+	if err != nil {
+		return err
+	}
+	var (
+		ideq  = quel.Equal(quel.NewIdent("replay_id"), quel.Arg("id", id))
+		jobeq = quel.Equal(quel.NewIdent("replay_status_id"), sub)
+		and   = quel.And(ideq, jobeq)
+	)
+	options := []quel.SelectOption{
+		quel.SelectColumn(quel.NewIdent("replay_id")),
+		quel.SelectWhere(and),
+	}
+	q, err := quel.NewSelect("replay_job", options...)
+	if err != nil {
+		return err
+	}
+	query, args, err := q.SQL()
+	if err != nil {
+		return err
+	}
+	err = s.db.QueryRowContext(ctx, query, args...).Scan(&id)
+	if err == nil {
+		err = fmt.Errorf("%w: replay job already cancelled", ErrQuery)
+	} else if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	return err
+}
+
+var variableColumns = []string{
+	"id",
+	"name",
+	"value",
+	"type",
+	"min_value",
+	"max_value",
+	"allowed_values",
+	"hazardous",
+}
+
+func scanVariable(row interface{ Scan(...interface{}) error }, v *Variable) error {
+	var min, max, allowed sql.NullString
+	err := row.Scan(&v.Id, &v.Name, &v.Value, &v.Type, &min, &max, &allowed, &v.Hazardous)
+	if err == nil {
+		v.setRange(min.String, max.String, allowed.String)
+	}
+	return err
+}
+
+var pendingColumns = []string{
+	"id",
+	"variable_id",
+	"timestamp",
+	"expires",
+	"author",
+	"value",
+	"token",
+}
+
+func scanPendingChange(row interface{ Scan(...interface{}) error }, p *PendingChange) error {
+	err := row.Scan(&p.Id, &p.Variable, &p.When, &p.Expires, &p.Author, &p.Value, &p.Token)
+	if err == nil {
+		p.When = p.When.UTC()
+		p.Expires = p.Expires.UTC()
+	}
+	return err
+}
+
+func (s DBStore) retrVariable(ctx context.Context, id int, v *Variable) error {
+	options := []quel.SelectOption{
+		quel.SelectColumns(variableColumns...),
+		quel.SelectWhere(quel.Equal(quel.NewIdent("id"), quel.Arg("id", id))),
+	}
+	q, err := quel.NewSelect("variable", options...)
+	if err != nil {
+		return err
+	}
+	query, args, err := q.SQL()
+	if err != nil {
+		return err
+	}
+	return scanVariable(s.db.QueryRowContext(ctx, query, args...), v)
+}
+
+// retrReplay reads the replay whatever its age, unlike the replays listed.
+func (s DBStore) retrReplay(ctx context.Context, id int, r *Replay) error {
+	where := quel.Equal(quel.NewIdent("id", "r"), quel.Arg("id", id))
+	q, err := prepareSelectReplay("replay_detail", where, nil)
+	if err != nil {
+		return err
+	}
+	query, args, err := q.SQL()
 	if err != nil {
 		return err
 	}
-	sql, _, err := q.SQL()
+	return s.db.QueryRowContext(ctx, query, args...).Scan(&r.Id, &r.When, &r.Starts, &r.Ends, &r.Priority, &r.Comment, &r.Status, &r.Automatic, &r.Cancellable, &r.Corrupted, &r.Missing)
+}
+
+func (s DBStore) registerReplay(ctx context.Context, tx *sql.Tx, r *Replay) error {
+	insert := []quel.InsertOption{
+		quel.InsertColumns("timestamp", "startdate", "enddate", "priority"),
+		quel.InsertValues(s.now(), quel.Arg("dtstart", r.Starts), quel.Arg("dtend", r.Ends), quel.Arg("priority", r.Priority)),
+	}
+	i, err := quel.NewInsert("replay", insert...)
 	if err != nil {
 		return err
 	}
-	return tx.QueryRow(sql).Scan(&r.Id)
+	r.Id, err = s.insert(ctx, tx, i)
+	return err
 }
 
-func (s DBStore) registerReplayJob(tx *sql.Tx, r *Replay) error {
+func (s DBStore) registerReplayJob(ctx context.Context, tx *sql.Tx, r *Replay) error {
 	get, err := prepareRetrInitialStatus("id")
 	if err != nil {
 		return err
 	}
 	options := []quel.InsertOption{
 		quel.InsertColumns("timestamp", "text", "replay_id", "replay_status_id"),
-		quel.InsertValues(quel.Now(), quel.Arg("comment", r.Comment), quel.Arg("replay", r.Id), get),
+		quel.InsertValues(s.now(), quel.Arg("comment", r.Comment), quel.Arg("replay", r.Id), get),
 	}
 	i, err := quel.NewInsert("replay_job", options...)
 	if err == nil {
-		err = s.exec(tx, i, []string{"comment", "replay"})
+		err = s.exec(ctx, tx, i, []string{"comment", "replay"})
 	}
 	return err
 }
 
-func (s DBStore) countRequests(where quel.SQLer) int {
-	return s.countItems("replay", "r", where)
+func (s DBStore) registerReplayGroup(ctx context.Context, tx *sql.Tx, r Replay, mode Overlap, g GapGroup) ([]Replay, error) {
+	if !r.isValid() {
+		return nil, fmt.Errorf("%w: invalid period", ErrQuery)
+	}
+	others, err := s.overlappingReplays(ctx, tx, r.Period)
+	if err != nil {
+		return nil, err
+	}
+	if len(others) == 0 {
+		return s.registerReplays(ctx, tx, r, []Period{r.Period}, g)
+	}
+	switch mode {
+	case OverlapExtend:
+		return s.extendReplay(ctx, tx, r, others, g)
+	case OverlapSplit:
+		ps := make([]Period, 0, len(others))
+		for _, o := range others {
+			ps = append(ps, o.Period)
+		}
+		if ps = subtractPeriods(r.Period, ps); len(ps) == 0 {
+			return nil, conflictReplays(others)
+		}
+		return s.registerReplays(ctx, tx, r, ps, g)
+	default:
+		return nil, conflictReplays(others)
+	}
 }
 
-func (s DBStore) pendingTime() int {
-	q, err := quel.NewSelect("pending_duration", quel.SelectColumn(quel.NewIdent("duration")))
+// registerReplays registers a replay for each period and links to it the gaps
+// of g that it covers.
+func (s DBStore) registerReplays(ctx context.Context, tx *sql.Tx, r Replay, ps []Period, g GapGroup) ([]Replay, error) {
+	var rs []Replay
+	for _, p := range ps {
+		r.Period = p
+		if err := s.registerReplay(ctx, tx, &r); err != nil {
+			return nil, err
+		}
+		if err := s.registerReplayJob(ctx, tx, &r); err != nil {
+			return nil, err
+		}
+		hrd, vmu := g.within(p)
+		if err := s.registerReplayGaps(ctx, tx, r.Id, hrd, vmu); err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+// extendReplay extends the period of the first replay overlapping r to cover
+// the period of r and of all the others overlapping replays. These are then
+// cancelled. Only pending replays can be extended.
+func (s DBStore) extendReplay(ctx context.Context, tx *sql.Tx, r Replay, others []Replay, g GapGroup) ([]Replay, error) {
+	pending, err := s.pendingStatus(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	var running []Replay
+	for _, o := range others {
+		if o.Status != pending {
+			running = append(running, o)
+			continue
+		}
+		if o.Starts.Before(r.Starts) {
+			r.Starts = o.Starts
+		}
+		if o.Ends.After(r.Ends) {
+			r.Ends = o.Ends
+		}
+	}
+	if len(running) > 0 {
+		return nil, conflictReplays(running)
+	}
+	var (
+		keep    = others[0]
+		options = []quel.UpdateOption{
+			quel.UpdateColumn("startdate", quel.Arg("dtstart", r.Starts)),
+			quel.UpdateColumn("enddate", quel.Arg("dtend", r.Ends)),
+			quel.UpdateWhere(quel.Equal(quel.NewIdent("id"), quel.Arg("id", keep.Id))),
+		}
+	)
+	q, err := quel.NewUpdate("replay", options...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.exec(ctx, tx, q, []string{"dtstart", "dtend", "id"}); err != nil {
+		return nil, err
+	}
+	for _, o := range others[1:] {
+		if err := s.cancelReplay(ctx, tx, o.Id, fmt.Sprintf("merged into replay %d", keep.Id)); err != nil {
+			return nil, err
+		}
+	}
+	hrd, vmu := g.within(g.Period)
+	if err := s.registerReplayGaps(ctx, tx, keep.Id, hrd, vmu); err != nil {
+		return nil, err
+	}
+	keep.Period = r.Period
+	return []Replay{keep}, nil
+}
+
+// overlappingReplays gives the pending and running replays whose period
+// overlaps the given period. With MySQL, the replays overlapping the period
+// are locked until the end of tx so that concurrent requests can not register
+// overlapping replays. SQLite only allows one writer at a time.
+func (s DBStore) overlappingReplays(ctx context.Context, tx *sql.Tx, p Period) ([]Replay, error) {
+	if s.driver != DriverSQLite {
+		if err := s.lockReplays(ctx, tx, p); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		cdt   = quel.Equal(quel.NewIdent("cancellable", "r"), quel.NewLiteral(true))
+		where = quel.And(cdt, overlapPeriod("r", p))
+		order = Criteria{Field: "id", Order: "asc"}
+	)
+	q, err := prepareSelectReplay("replay_detail", where, []quel.SelectOption{order.orderBy()})
+	if err != nil {
+		return nil, err
+	}
+	return s.queryReplaysWith(ctx, tx, q)
+}
+
+func (s DBStore) lockReplays(ctx context.Context, tx *sql.Tx, p Period) error {
+	options := []quel.SelectOption{
+		quel.SelectAlias("r"),
+		quel.SelectColumn(quel.NewIdent("id", "r")),
+		quel.SelectWhere(overlapPeriod("r", p)),
+	}
+	q, err := quel.NewSelect("replay", options...)
 	if err != nil {
-		return 0
+		return err
 	}
 	query, args, err := q.SQL()
 	if err != nil {
-		return 0
+		return err
+	}
+	rows, err := tx.QueryContext(ctx, query+" for update", args...)
+	if err != nil {
+		return err
 	}
-	var count int
-	if err := s.db.QueryRow(query, args...).Scan(&count); err != nil {
-		return count
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+func (s DBStore) pendingStatus(ctx context.Context, tx *sql.Tx) (string, error) {
+	q, err := prepareRetrInitialStatus("name")
+	if err != nil {
+		return "", err
+	}
+	query, args, err := q.SQL()
+	if err != nil {
+		return "", err
+	}
+	var name string
+	return name, tx.QueryRowContext(ctx, query, args...).Scan(&name)
+}
+
+func (s DBStore) cancelReplay(ctx context.Context, tx *sql.Tx, id int, comment string) error {
+	get, err := prepareRetrCancelStatus("id")
+	if err != nil {
+		return err
+	}
+	options := []quel.InsertOption{
+		quel.InsertColumns("timestamp", "replay_id", "replay_status_id", "text"),
+		quel.InsertValues(s.now(), quel.Arg("id", id), get, quel.Arg("comment", comment)),
+	}
+	i, err := quel.NewInsert("replay_job", options...)
+	if err == nil {
+		err = s.exec(ctx, tx, i, []string{"id", "comment"})
+	}
+	return err
+}
+
+// registerReplayGaps links the given gaps to replay. The VMU gaps are linked
+// through the HRD gap they belong to and are skipped when they have none. A gap
+// already linked to another replay is moved to the new one so that it is never
+// listed nor counted twice.
+func (s DBStore) registerReplayGaps(ctx context.Context, tx *sql.Tx, replay int, hrd, vmu []int) error {
+	for _, id := range vmu {
+		gap, err := s.retrHRDGapOfVMU(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if gap > 0 {
+			hrd = append(hrd, gap)
+		}
+	}
+	seen := make(map[int]struct{})
+	for _, id := range hrd {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+
+		where := quel.Equal(quel.NewIdent("hrd_packet_gap_id"), quel.Arg("gap", id))
+		d, err := quel.NewDelete("gap_replay_list", quel.DeleteWhere(where))
+		if err == nil {
+			err = s.exec(ctx, tx, d, []string{"gap"})
+		}
+		if err != nil {
+			return err
+		}
+		options := []quel.InsertOption{
+			quel.InsertColumns("hrd_packet_gap_id", "replay_id"),
+			quel.InsertValues(quel.Arg("gap", id), quel.Arg("replay", replay)),
+		}
+		i, err := quel.NewInsert("gap_replay_list", options...)
+		if err == nil {
+			err = s.exec(ctx, tx, i, []string{"gap", "replay"})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retrHRDGapOfVMU gives the id of the HRD gap a VMU gap belongs to or 0 if it
+// has none.
+func (s DBStore) retrHRDGapOfVMU(ctx context.Context, tx *sql.Tx, id int) (int, error) {
+	options := []quel.SelectOption{
+		quel.SelectColumn(quel.NewIdent("hrd_packet_gap_id")),
+		quel.SelectWhere(quel.Equal(quel.NewIdent("id"), quel.Arg("gap", id))),
+	}
+	q, err := quel.NewSelect("vmu_packet_gap", options...)
+	if err != nil {
+		return 0, err
+	}
+	var gap sql.NullInt64
+	err = s.queryWith(ctx, tx, q, func(rows *sql.Rows) error {
+		return rows.Scan(&gap)
+	})
+	return int(gap.Int64), err
+}
+
+func (s DBStore) registerVariableVersion(ctx context.Context, tx *sql.Tx, id int, value string) error {
+	options := []quel.InsertOption{
+		quel.InsertColumns("variable_id", "timestamp", "author", "value"),
+		quel.InsertValues(quel.Arg("id", id), s.now(), quel.Arg("author", actorFromContext(ctx)), quel.Arg("value", value)),
+	}
+	i, err := quel.NewInsert("variable_history", options...)
+	if err == nil {
+		err = s.exec(ctx, tx, i, []string{"id", "author", "value"})
+	}
+	return err
+}
+
+func (s DBStore) checkVariableName(ctx context.Context, tx *sql.Tx, name string) error {
+	options := []quel.SelectOption{
+		quel.SelectColumns("id"),
+		quel.SelectWhere(quel.Equal(quel.NewIdent("name"), quel.Arg("name", name))),
+	}
+	q, err := quel.NewSelect("variable", options...)
+	if err != nil {
+		return err
+	}
+	query, args, err := q.SQL()
+	if err != nil {
+		return err
+	}
+	var id int
+	switch err := tx.QueryRowContext(ctx, query, args...).Scan(&id); err {
+	case nil:
+		return fmt.Errorf("%w: variable %s already exists (%d)", ErrConflict, name, id)
+	case sql.ErrNoRows:
+		return nil
+	default:
+		return err
+	}
+}
+
+func (s DBStore) retrPendingChange(ctx context.Context, tx *sql.Tx, id int) (PendingChange, error) {
+	var p PendingChange
+	q, err := prepareSelectPendingChange(id)
+	if err != nil {
+		return p, err
+	}
+	query, args, err := q.SQL()
+	if err != nil {
+		return p, err
+	}
+	err = scanPendingChange(tx.QueryRowContext(ctx, query, args...), &p)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = fmt.Errorf("%w: pending change %d not found", ErrExist, id)
+	}
+	return p, err
+}
+
+// resolvePendingChange resolves the change if it is still pending. It fails
+// when the change has been resolved by another request in the meantime.
+func (s DBStore) resolvePendingChange(ctx context.Context, tx *sql.Tx, id int, status string) error {
+	where := quel.And(
+		quel.Equal(quel.NewIdent("id"), quel.Arg("id", id)),
+		quel.Equal(quel.NewIdent("status"), quel.Arg("pending", ChangePending)),
+	)
+	options := []quel.UpdateOption{
+		quel.UpdateColumn("status", quel.Arg("status", status)),
+		quel.UpdateColumn("resolver", quel.Arg("resolver", actorFromContext(ctx))),
+		quel.UpdateWhere(where),
+	}
+	q, err := quel.NewUpdate("variable_pending", options...)
+	if err != nil {
+		return err
+	}
+	query, args, err := q.SQL()
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n != 1 {
+		return fmt.Errorf("%w: pending change %d already resolved", ErrConflict, id)
+	}
+	return nil
+}
+
+func (s DBStore) now() quel.SQLer {
+	if s.driver == DriverSQLite {
+		return quel.Func("DATETIME", quel.Arg("now", "now"))
+	}
+	return quel.Now()
+}
+
+func (s DBStore) today() quel.SQLer {
+	if s.driver == DriverSQLite {
+		return quel.Func("DATE", quel.Arg("now", "now"))
+	}
+	return quel.Func("current_date")
+}
+
+func (s DBStore) daysBack(days int) quel.SQLer {
+	if s.driver == DriverSQLite {
+		return quel.Func("DATE", quel.Arg("now", "now"), quel.Arg("days", fmt.Sprintf("-%d days", days)))
 	}
-	return count
+	return quel.Func("DATE_SUB", quel.NewIdent("CURRENT_DATE"), quel.Days(days))
 }
 
-func (s DBStore) countGapsHRD(where quel.SQLer) int {
-	return s.countItems("hrd_packet_gap", "r", where)
+func (s DBStore) countRequests(ctx context.Context, where quel.SQLer) (int, error) {
+	return s.countItems(ctx, "replay", "r", where)
 }
 
-func (s DBStore) countGapsVMU(where quel.SQLer) int {
-	return s.countItems("vmu_packet_gap", "r", where)
+func (s DBStore) countGapsHRD(ctx context.Context, where quel.SQLer) (int, error) {
+	return s.countItems(ctx, "hrd_packet_gap", "r", where)
 }
 
-func (s DBStore) countItems(table, alias string, where quel.SQLer) int {
+func (s DBStore) countGapsVMU(ctx context.Context, where quel.SQLer) (int, error) {
+	return s.countItems(ctx, "vmu_packet_gap", "r", where)
+}
+
+func (s DBStore) countItems(ctx context.Context, table, alias string, where quel.SQLer) (int, error) {
 	options := []quel.SelectOption{
 		quel.SelectColumn(quel.Count(quel.NewIdent("id"))),
 		quel.SelectAlias(alias),
@@ -613,17 +1969,103 @@ func (s DBStore) countItems(table, alias string, where quel.SQLer) int {
 	}
 	q, err := quel.NewSelect(table, options...)
 	if err != nil {
-		return 0
+		return 0, err
 	}
 	query, args, err := q.SQL()
 	if err != nil {
-		return 0
+		return 0, err
 	}
 	var count int
-	if err := s.db.QueryRow(query, args...).Scan(&count); err != nil {
-		return count
+	return count, s.db.QueryRowContext(ctx, query, args...).Scan(&count)
+}
+
+// scanTime scans dates given as text by drivers that can not infer the type of
+// computed columns (eg: date(timestamp) with SQLite).
+type scanTime struct {
+	*time.Time
+}
+
+func (t scanTime) Scan(v interface{}) error {
+	var str string
+	switch v := v.(type) {
+	case time.Time:
+		*t.Time = v
+		return nil
+	case nil:
+		return nil
+	case []byte:
+		str = string(v)
+	case string:
+		str = v
+	default:
+		return fmt.Errorf("can not scan %T into time", v)
+	}
+	for _, pat := range []string{"2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05", "2006-01-02", time.RFC3339Nano} {
+		w, err := time.Parse(pat, str)
+		if err == nil {
+			*t.Time = w
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: invalid time", str)
+}
+
+func nullText(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
+
+func nullString(str string) interface{} {
+	if str == "" {
+		return nil
+	}
+	return str
+}
+
+func conflictReplays(rs []Replay) error {
+	var str []string
+	for _, r := range rs {
+		str = append(str, fmt.Sprintf("%d (%s - %s, %s)", r.Id, r.Starts.Format(time.RFC3339), r.Ends.Format(time.RFC3339), r.Status))
+	}
+	return fmt.Errorf("%w: period overlaps replay(s) %s", ErrConflict, strings.Join(str, ", "))
+}
+
+// overlapPeriod selects the replays overlapping p. Periods are half-open: a
+// replay ending when p starts (or starting when p ends) does not overlap p as
+// computed by Period.overlaps.
+func overlapPeriod(alias string, p Period) quel.SQLer {
+	fst := quel.Lesser(quel.NewIdent("startdate", alias), quel.Arg("dtend", p.Ends))
+	if p.Starts.Equal(p.Ends) {
+		fst = quel.LesserOrEqual(quel.NewIdent("startdate", alias), quel.Arg("dtend", p.Ends))
+	}
+	lst := quel.Greater(quel.NewIdent("enddate", alias), quel.Arg("dtstart", p.Starts))
+	return quel.And(fst, lst)
+}
+
+func siblingGap(alias string, id int, next bool) (quel.SQLer, []quel.SelectOption) {
+	c := Criteria{
+		Field: "id",
+		Limit: 1,
+	}
+	var where quel.SQLer
+	if next {
+		c.Order = "asc"
+		where = quel.GreaterOrEqual(quel.NewIdent("id", alias), quel.Arg("id", id+1))
+	} else {
+		c.Order = "desc"
+		where = quel.LesserOrEqual(quel.NewIdent("id", alias), quel.Arg("id", id-1))
 	}
-	return count
+	return where, c.orderAndLimits()
+}
+
+func prepareSelectPendingChange(id int) (quel.Select, error) {
+	where := quel.And(
+		quel.Equal(quel.NewIdent("id"), quel.Arg("id", id)),
+		quel.Equal(quel.NewIdent("status"), quel.Arg("status", ChangePending)),
+	)
+	return quel.NewSelect("variable_pending", quel.SelectColumns(pendingColumns...), quel.SelectWhere(where))
 }
 
 func prepareRetrCancelStatus(field string) (quel.Select, error) {
@@ -687,7 +2129,9 @@ func prepareCountStatusQuery() (quel.Select, error) {
 	return quel.NewSelect("replay_job", options...)
 }
 
-func prepareSelectReplay(where quel.SQLer, limits []quel.SelectOption) (quel.SQLer, error) {
+// prepareSelectReplay selects the replays from replay_list, limited to the
+// replays of the last days, or from replay_detail that gives any replay.
+func prepareSelectReplay(table string, where quel.SQLer, limits []quel.SelectOption) (quel.SQLer, error) {
 	options := []quel.SelectOption{
 		quel.SelectAlias("r"),
 		quel.SelectColumn(quel.NewIdent("id", "r")),
@@ -704,10 +2148,10 @@ func prepareSelectReplay(where quel.SQLer, limits []quel.SelectOption) (quel.SQL
 		quel.SelectWhere(where),
 	}
 	options = append(options, limits...)
-	return quel.NewSelect("replay_list", options...)
+	return quel.NewSelect(table, options...)
 }
 
-func prepareSelectGapsVMU(where quel.SQLer, limits []quel.SelectOption) (quel.SQLer, error) {
+func prepareSelectGapsVMU(table string, where quel.SQLer, limits []quel.SelectOption) (quel.SQLer, error) {
 	options := []quel.SelectOption{
 		quel.SelectColumn(quel.NewIdent("id", "g")),
 		quel.SelectColumn(quel.NewIdent("timestamp", "g")),
@@ -723,10 +2167,10 @@ func prepareSelectGapsVMU(where quel.SQLer, limits []quel.SelectOption) (quel.SQ
 		quel.SelectWhere(where),
 	}
 	options = append(options, limits...)
-	return quel.NewSelect("vmu_gap_list", options...)
+	return quel.NewSelect(table, options...)
 }
 
-func prepareSelectGapsHRD(where quel.SQLer, limits []quel.SelectOption) (quel.SQLer, error) {
+func prepareSelectGapsHRD(table string, where quel.SQLer, limits []quel.SelectOption) (quel.SQLer, error) {
 	options := []quel.SelectOption{
 		quel.SelectColumn(quel.NewIdent("id", "r")),
 		quel.SelectColumn(quel.NewIdent("timestamp", "r")),
@@ -741,5 +2185,5 @@ func prepareSelectGapsHRD(where quel.SQLer, limits []quel.SelectOption) (quel.SQ
 		quel.SelectAlias("r"),
 	}
 	options = append(options, limits...)
-	return quel.NewSelect("hrd_gap_list", options...)
+	return quel.NewSelect(table, options...)
 }
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EventReplay   = "replay"
+	EventGapHRD   = "gap.hrd"
+	EventGapVMU   = "gap.vmu"
+	EventVariable = "variable"
+)
+
+const (
+	DefaultEventInterval = time.Second * 5
+	MaxEvents            = 500
+)
+
+type EventConfig struct {
+	Interval int
+}
+
+// PollInterval gives the time (configured in seconds) between two lookups
+// of new events in the database.
+func (c EventConfig) PollInterval() time.Duration {
+	if c.Interval <= 0 {
+		return DefaultEventInterval
+	}
+	return time.Duration(c.Interval) * time.Second
+}
+
+// EventCursor keeps the id of the last row seen in each of the tables watched
+// for events. It is given as id of the events sent so that a client can resume
+// its stream with the Last-Event-ID header.
+type EventCursor struct {
+	Job      int
+	HRD      int
+	VMU      int
+	Variable int
+}
+
+func parseEventCursor(str string) (EventCursor, error) {
+	var (
+		c     EventCursor
+		parts = strings.Split(str, "-")
+		ids   = []*int{&c.Job, &c.HRD, &c.VMU, &c.Variable}
+	)
+	if len(parts) != len(ids) {
+		return c, fmt.Errorf("%w: invalid event id %s", ErrQuery, str)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return c, fmt.Errorf("%w: invalid event id %s", ErrQuery, str)
+		}
+		*ids[i] = n
+	}
+	return c, nil
+}
+
+func (c EventCursor) String() string {
+	return fmt.Sprintf("%d-%d-%d-%d", c.Job, c.HRD, c.VMU, c.Variable)
+}
+
+func (c *EventCursor) update(e Event) {
+	var id *int
+	switch e.Type {
+	case EventReplay:
+		id = &c.Job
+	case EventGapHRD:
+		id = &c.HRD
+	case EventGapVMU:
+		id = &c.VMU
+	case EventVariable:
+		id = &c.Variable
+	default:
+		return
+	}
+	if e.Id > *id {
+		*id = e.Id
+	}
+}
+
+// Event is a change found in the database. Id is the id of the row that
+// triggered the event in its own table.
+type Event struct {
+	Id     int
+	Type   string
+	When   time.Time
+	Data   interface{}
+	Cursor EventCursor
+}
+
+type ReplayEvent struct {
+	Replay int `json:"replay"`
+	Job
+}
+
+type VariableEvent struct {
+	Variable int `json:"variable"`
+	VariableVersion
+}
+
+type EventStore interface {
+	FetchEventCursor(context.Context) (EventCursor, error)
+	FetchEvents(context.Context, EventCursor) ([]Event, error)
+}
+
+// sortEvents orders es by time and set the cursor of each event starting from
+// the given cursor. Events of a table are not always ordered by time as they
+// are by id: the cursor of a table only moves past an id once all the events
+// of the table with a lower id have been sent before it.
+func sortEvents(from EventCursor, es []Event) []Event {
+	pending := make(map[string][]int)
+	for _, e := range es {
+		pending[e.Type] = append(pending[e.Type], e.Id)
+	}
+	for _, ids := range pending {
+		sort.Ints(ids)
+	}
+	sort.SliceStable(es, func(i, j int) bool {
+		return es[i].When.Before(es[j].When)
+	})
+	sent := make(map[string]map[int]struct{})
+	for i, e := range es {
+		if sent[e.Type] == nil {
+			sent[e.Type] = make(map[int]struct{})
+		}
+		sent[e.Type][e.Id] = struct{}{}
+
+		ids := pending[e.Type]
+		for len(ids) > 0 {
+			if _, ok := sent[e.Type][ids[0]]; !ok {
+				break
+			}
+			from.update(Event{Id: ids[0], Type: e.Type})
+			ids = ids[1:]
+		}
+		pending[e.Type] = ids
+		es[i].Cursor = from
+	}
+	return es
+}
+
+func streamEvents(db EventStore, every, timeout time.Duration) http.Handler {
+	fetch := func(ctx context.Context, c EventCursor) ([]Event, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		es, err := db.FetchEvents(ctx, c)
+		if errors.Is(err, ErrEmpty) {
+			err = nil
+		}
+		return es, err
+	}
+	cursor := func(r *http.Request) (EventCursor, error) {
+		id := r.Header.Get("Last-Event-ID")
+		if id == "" {
+			id = r.URL.Query().Get("lastEventId")
+		}
+		if id != "" {
+			return parseEventCursor(id)
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		return db.FetchEventCursor(ctx)
+	}
+	next := func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, fmt.Errorf("%w: streaming not supported", ErrImpl))
+			return
+		}
+		c, err := cursor(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		f.Flush()
+
+		tick := time.NewTicker(every)
+		defer tick.Stop()
+		for {
+			es, err := fetch(r.Context(), c)
+			if err != nil && r.Context().Err() == nil {
+				fmt.Fprintf(w, ": %s\n\n", err)
+			}
+			for _, e := range es {
+				if err := writeEvent(w, e.Cursor.String(), e.Type, e.Data); err != nil {
+					return
+				}
+				c = e.Cursor
+			}
+			if len(es) == 0 {
+				io.WriteString(w, ":\n\n")
+			}
+			f.Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-tick.C:
+			}
+		}
+	}
+	return http.HandlerFunc(next)
+}
+
+func writeEvent(w io.Writer, id, kind string, data interface{}) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", kind, buf)
+	return err
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEventCursor(t *testing.T) {
+	c, err := parseEventCursor("1-2-3-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := EventCursor{Job: 1, HRD: 2, VMU: 3, Variable: 4}
+	if c != want {
+		t.Errorf("unexpected cursor: want %+v, got %+v", want, c)
+	}
+	if str := c.String(); str != "1-2-3-4" {
+		t.Errorf("unexpected cursor string: %s", str)
+	}
+	for _, str := range []string{"", "1-2-3", "1-2-3-x", "1-2--3-4"} {
+		if _, err := parseEventCursor(str); err == nil {
+			t.Errorf("%s: expected error", str)
+		}
+	}
+}
+
+func TestSortEvents(t *testing.T) {
+	var (
+		now = time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+		es  = []Event{
+			{Id: 4, Type: EventGapHRD, When: now.Add(time.Minute)},
+			{Id: 5, Type: EventGapHRD, When: now},
+			{Id: 6, Type: EventGapHRD, When: now.Add(2 * time.Minute)},
+			{Id: 8, Type: EventReplay, When: now.Add(30 * time.Second)},
+		}
+		want = []EventCursor{
+			{Job: 7, HRD: 3},
+			{Job: 8, HRD: 3},
+			{Job: 8, HRD: 5},
+			{Job: 8, HRD: 6},
+		}
+	)
+	es = sortEvents(EventCursor{Job: 7, HRD: 3}, es)
+	for i, e := range es {
+		if e.Cursor != want[i] {
+			t.Errorf("event %d (%s): want cursor %+v, got %+v", e.Id, e.Type, want[i], e.Cursor)
+		}
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	db := newMemStore()
+	handler := streamEvents(db, time.Millisecond*10, time.Second)
+
+	events := func(lastId string) ([]string, []string) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+
+		req := httptest.NewRequest(http.MethodGet, "/events/", nil).WithContext(ctx)
+		req.Header.Set("Accept", "text/event-stream")
+		if lastId != "" {
+			req.Header.Set("Last-Event-ID", lastId)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status code: want %d, got %d", http.StatusOK, rec.Code)
+		}
+		var ids, kinds []string
+		scan := bufio.NewScanner(rec.Body)
+		for scan.Scan() {
+			line := scan.Text()
+			if strings.HasPrefix(line, "id: ") {
+				ids = append(ids, strings.TrimPrefix(line, "id: "))
+			}
+			if strings.HasPrefix(line, "event: ") {
+				kinds = append(kinds, strings.TrimPrefix(line, "event: "))
+			}
+		}
+		return ids, kinds
+	}
+
+	ids, kinds := events("0-0-0-0")
+	if len(kinds) != 5 {
+		t.Fatalf("unexpected number of events: want 5, got %d (%v)", len(kinds), kinds)
+	}
+	last := ids[len(ids)-1]
+
+	if ids, _ = events(last); len(ids) != 0 {
+		t.Errorf("unexpected events after resume: %v", ids)
+	}
+	if ids, _ = events(""); len(ids) != 0 {
+		t.Errorf("unexpected events without Last-Event-ID: %v", ids)
+	}
+	if _, err := db.CancelReplay(context.Background(), 1, "test"); err != nil {
+		t.Fatal(err)
+	}
+	ids, kinds = events(last)
+	if len(kinds) != 1 || kinds[0] != EventReplay {
+		t.Fatalf("unexpected events after cancel: %v", kinds)
+	}
+	if ids[0] != "2-1-1-2" {
+		t.Errorf("unexpected event id: want 2-1-1-2, got %s", ids[0])
+	}
+}
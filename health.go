@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+	HealthSkip = "skip"
+)
+
+const DefaultReadyTimeout = 2 * time.Second
+
+const (
+	pathLive  = "/healthz"
+	pathReady = "/readyz"
+)
+
+// HealthConfig gives the time (in seconds) given to each check of /readyz.
+type HealthConfig struct {
+	Timeout int
+}
+
+func (c HealthConfig) Deadline() time.Duration {
+	return seconds(c.Timeout, DefaultReadyTimeout)
+}
+
+type Check struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration"`
+	Err      string  `json:"error,omitempty"`
+}
+
+type Health struct {
+	Status string  `json:"status"`
+	Uptime int64   `json:"uptime,omitempty"`
+	Checks []Check `json:"checks,omitempty"`
+}
+
+// checkLive reports that otto is up and able to answer requests.
+func checkLive(started time.Time) http.Handler {
+	do := func(w http.ResponseWriter, r *http.Request) {
+		h := Health{
+			Status: HealthOK,
+			Uptime: int64(time.Since(started).Seconds()),
+		}
+		writeHealth(w, h)
+	}
+	return http.HandlerFunc(do)
+}
+
+// checkReady reports whether otto can serve requests: the database answers
+// before the deadline with the schema expected and each monitored process can
+// be found from its pidfile or its command. Checks not supported by the store
+// are skipped.
+func checkReady(db Store, mons []Monitor, deadline time.Duration) http.Handler {
+	type check struct {
+		Name  string
+		Check func(context.Context) error
+	}
+	checks := []check{
+		{Name: "database", Check: pingStore(db)},
+		{Name: "schema", Check: checkStoreSchema(db)},
+	}
+	for _, m := range mons {
+		checks = append(checks, check{Name: "process." + m.Name, Check: findProcess(m)})
+	}
+	do := func(w http.ResponseWriter, r *http.Request) {
+		h := Health{Status: HealthOK}
+		for _, c := range checks {
+			x := runCheck(r.Context(), c.Name, deadline, c.Check)
+			if x.Status == HealthFail {
+				h.Status = HealthFail
+			}
+			h.Checks = append(h.Checks, x)
+		}
+		writeHealth(w, h)
+	}
+	return http.HandlerFunc(do)
+}
+
+// errSkip is returned by the checks that can not be done.
+var errSkip = errors.New("skip")
+
+func runCheck(ctx context.Context, name string, deadline time.Duration, check func(context.Context) error) Check {
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+
+	var (
+		now = time.Now()
+		err = check(ctx)
+		c   = Check{
+			Name:     name,
+			Status:   HealthOK,
+			Duration: time.Since(now).Seconds(),
+		}
+	)
+	switch {
+	case errors.Is(err, errSkip):
+		c.Status = HealthSkip
+	case err != nil:
+		c.Status = HealthFail
+		c.Err = err.Error()
+	}
+	return c
+}
+
+func pingStore(db Store) func(context.Context) error {
+	return func(ctx context.Context) error {
+		p, ok := db.(interface{ Ping(context.Context) error })
+		if !ok {
+			return errSkip
+		}
+		return p.Ping(ctx)
+	}
+}
+
+func checkStoreSchema(db Store) func(context.Context) error {
+	return func(ctx context.Context) error {
+		c, ok := db.(interface{ CheckSchema(context.Context) error })
+		if !ok {
+			return errSkip
+		}
+		return c.CheckSchema(ctx)
+	}
+}
+
+// findProcess checks that the pidfile of mon gives a pid or, without
+// pidfile, that a process runs the command of mon.
+func findProcess(mon Monitor) func(context.Context) error {
+	return func(_ context.Context) error {
+		if mon.Pid == "" {
+			if mon.Command == "" {
+				return errSkip
+			}
+			if _, err := mon.readPid(); err != nil {
+				return fmt.Errorf("%s: no process running %s", mon.Name, mon.Command)
+			}
+			return nil
+		}
+		buf, err := ioutil.ReadFile(mon.Pid)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(buf)) == "" {
+			return fmt.Errorf("%s: empty pidfile", mon.Pid)
+		}
+		return nil
+	}
+}
+
+func writeHealth(w http.ResponseWriter, h Health) {
+	code := http.StatusOK
+	if h.Status != HealthOK {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(h)
+}
+
+// withoutProbes keeps the requests of the probes out of the access log by
+// giving them to next instead of logged.
+func withoutProbes(next, logged http.Handler) http.Handler {
+	do := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case pathLive, pathReady:
+			next.ServeHTTP(w, r)
+		default:
+			logged.ServeHTTP(w, r)
+		}
+	}
+	return http.HandlerFunc(do)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pingMemStore is a memStore whose database answers to Ping with err.
+type pingMemStore struct {
+	*memStore
+	err error
+}
+
+func (s pingMemStore) Ping(_ context.Context) error {
+	return s.err
+}
+
+func TestProbes(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		conf Config
+	)
+	conf.Auth.Tokens = []TokenConfig{{User: "admin", Token: "fedcba"}}
+	conf.Mon = Monitor{Pid: filepath.Join(dir, "autobrm.pid"), Proc: dir}
+	conf.Procs = []Monitor{{Name: "archiver", Pid: filepath.Join(dir, "archiver.pid")}}
+
+	probe := func(db Store, url string) (int, Health) {
+		t.Helper()
+		handler, err := setupRoutes(db, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+
+		var h Health
+		if err := json.NewDecoder(rec.Body).Decode(&h); err != nil {
+			t.Fatalf("%s: invalid body: %s", url, err)
+		}
+		return rec.Code, h
+	}
+	status := func(h Health, name string) string {
+		for _, c := range h.Checks {
+			if c.Name == name {
+				return c.Status
+			}
+		}
+		return ""
+	}
+
+	if code, h := probe(newMemStore(), pathLive); code != http.StatusOK || h.Status != HealthOK {
+		t.Errorf("unexpected liveness: %d (%+v)", code, h)
+	}
+	code, h := probe(newMemStore(), pathReady)
+	if code != http.StatusServiceUnavailable || h.Status != HealthFail {
+		t.Errorf("unexpected readiness without pidfile: %d (%+v)", code, h)
+	}
+	if s := status(h, "process.autobrm"); s != HealthFail {
+		t.Errorf("process.autobrm: unexpected status %s", s)
+	}
+	if s := status(h, "database"); s != HealthSkip {
+		t.Errorf("database: unexpected status %s", s)
+	}
+
+	if err := os.WriteFile(conf.Mon.Pid, []byte("42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	code, h = probe(pingMemStore{memStore: newMemStore()}, pathReady)
+	if code != http.StatusServiceUnavailable || status(h, "process.autobrm") != HealthOK || status(h, "process.archiver") != HealthFail {
+		t.Errorf("unexpected readiness without pidfile of archiver: %d (%+v)", code, h)
+	}
+	if err := os.WriteFile(conf.Procs[0].Pid, []byte("43\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	code, h = probe(pingMemStore{memStore: newMemStore()}, pathReady)
+	if code != http.StatusOK || status(h, "database") != HealthOK || status(h, "process.archiver") != HealthOK {
+		t.Errorf("unexpected readiness: %d (%+v)", code, h)
+	}
+	code, h = probe(pingMemStore{memStore: newMemStore(), err: errors.New("connection refused")}, pathReady)
+	if code != http.StatusServiceUnavailable || status(h, "database") != HealthFail {
+		t.Errorf("unexpected readiness with database down: %d (%+v)", code, h)
+	}
+}
+
+func TestWithoutProbes(t *testing.T) {
+	var logged int
+	var (
+		next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		log  = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { logged++ })
+		h    = withoutProbes(next, log)
+	)
+	for _, url := range []string{pathLive, pathReady, "/status/"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+	}
+	if logged != 1 {
+		t.Errorf("unexpected number of requests logged: want 1, got %d", logged)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHistoryInterval = time.Minute
+	DefaultHistorySize     = 1440
+)
+
+// HistoryConfig configures the sampling of a monitored process. Size is the
+// number of samples kept. When Persist is set, the samples are also saved in
+// the database and survive a restart of otto.
+type HistoryConfig struct {
+	Interval int
+	Size     int
+	Persist  bool
+}
+
+func (c HistoryConfig) SampleInterval() time.Duration {
+	return seconds(c.Interval, DefaultHistoryInterval)
+}
+
+func (c HistoryConfig) Capacity() int {
+	if c.Size <= 0 {
+		return DefaultHistorySize
+	}
+	return c.Size
+}
+
+type ProcessSample struct {
+	Process string    `json:"process"`
+	When    time.Time `json:"time"`
+	Pid     string    `json:"pid"`
+	State   string    `json:"state"`
+	RSS     int64     `json:"vmrss"`
+	VMSize  int64     `json:"vmsize"`
+	CPU     float64   `json:"cpu"`
+}
+
+type ProcessStore interface {
+	RegisterProcessSample(context.Context, ProcessSample) error
+	FetchProcessHistory(context.Context, string, Criteria) ([]ProcessSample, error)
+}
+
+// sampleProcess gives the sample of the status read by Monitor.readProcess.
+func sampleProcess(status map[string]string, when time.Time) ProcessSample {
+	s := ProcessSample{
+		When:  when.UTC(),
+		Pid:   status["pid"],
+		State: status["state"],
+	}
+	if fs := strings.Fields(s.State); len(fs) > 0 {
+		s.State = fs[0]
+	}
+	if status["stale"] == "true" {
+		s.State = "stale"
+	}
+	if n, ok := parseMemory(status["vmrss"]); ok {
+		s.RSS = int64(n)
+	}
+	if n, ok := parseMemory(status["vmsize"]); ok {
+		s.VMSize = int64(n)
+	}
+	if n, err := strconv.ParseFloat(status["cpu"], 64); err == nil {
+		s.CPU = n
+	}
+	return s
+}
+
+// sampleRing keeps the last samples registered. Once full, a new sample
+// replaces the oldest one.
+type sampleRing struct {
+	mu      sync.Mutex
+	samples []ProcessSample
+	next    int
+	full    bool
+}
+
+func newSampleRing(size int) *sampleRing {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	return &sampleRing{samples: make([]ProcessSample, size)}
+}
+
+// newSampleRings gives the ring of each monitored process by name.
+func newSampleRings(mons []Monitor) map[string]*sampleRing {
+	rs := make(map[string]*sampleRing)
+	for _, m := range mons {
+		rs[m.Name] = newSampleRing(m.History.Capacity())
+	}
+	return rs
+}
+
+// add registers s and gives the oldest sample still kept when the ring is full.
+func (r *sampleRing) add(s ProcessSample) (ProcessSample, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.samples[r.next] = s
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+	if !r.full {
+		return ProcessSample{}, false
+	}
+	return r.samples[r.next], true
+}
+
+// between gives in chronological order the samples taken in the given period.
+// A zero time leaves the period open on its side.
+func (r *sampleRing) between(starts, ends time.Time) []ProcessSample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var (
+		vs    []ProcessSample
+		first int
+		count = r.next
+	)
+	if r.full {
+		first, count = r.next, len(r.samples)
+	}
+	for i := 0; i < count; i++ {
+		s := r.samples[(first+i)%len(r.samples)]
+		if !starts.IsZero() && s.When.Before(starts) {
+			continue
+		}
+		if !ends.IsZero() && s.When.After(ends) {
+			continue
+		}
+		vs = append(vs, s)
+	}
+	return vs
+}
+
+// Sampler reads periodically the status of a monitored process and registers
+// it in the history of its store.
+type Sampler struct {
+	db      ProcessStore
+	mon     Monitor
+	every   time.Duration
+	timeout time.Duration
+}
+
+func NewSampler(db ProcessStore, mon Monitor, timeout time.Duration) *Sampler {
+	return &Sampler{
+		db:      db,
+		mon:     mon.withDefaults(),
+		every:   mon.History.SampleInterval(),
+		timeout: timeout,
+	}
+}
+
+func (s *Sampler) Run(ctx context.Context) {
+	tick := time.NewTicker(s.every)
+	defer tick.Stop()
+	for {
+		if err := s.Sample(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "fail to sample process %s: %s\n", s.mon.Name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func (s *Sampler) Sample(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	p := sampleProcess(s.mon.readProcess(), time.Now())
+	p.Process = s.mon.Name
+	return s.db.RegisterProcessSample(ctx, p)
+}
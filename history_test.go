@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSampleRing(t *testing.T) {
+	var (
+		r   = newSampleRing(3)
+		now = time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	)
+	for i := 0; i < 2; i++ {
+		if _, full := r.add(ProcessSample{When: now.Add(time.Duration(i) * time.Minute)}); full {
+			t.Fatalf("ring full after %d samples", i+1)
+		}
+	}
+	if vs := r.between(time.Time{}, time.Time{}); len(vs) != 2 {
+		t.Fatalf("unexpected number of samples: want 2, got %d", len(vs))
+	}
+	for i := 2; i < 5; i++ {
+		r.add(ProcessSample{When: now.Add(time.Duration(i) * time.Minute)})
+	}
+	vs := r.between(time.Time{}, time.Time{})
+	if len(vs) != 3 {
+		t.Fatalf("unexpected number of samples: want 3, got %d", len(vs))
+	}
+	for i, v := range vs {
+		if want := now.Add(time.Duration(i+2) * time.Minute); !v.When.Equal(want) {
+			t.Errorf("sample %d: want %s, got %s", i, want, v.When)
+		}
+	}
+	vs = r.between(now.Add(3*time.Minute), now.Add(3*time.Minute))
+	if len(vs) != 1 || !vs[0].When.Equal(now.Add(3*time.Minute)) {
+		t.Errorf("unexpected samples in period: %v", vs)
+	}
+	oldest, full := r.add(ProcessSample{When: now.Add(5 * time.Minute)})
+	if !full || !oldest.When.Equal(now.Add(3*time.Minute)) {
+		t.Errorf("unexpected oldest sample: %v (full: %t)", oldest.When, full)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "42"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	status := "Name:\tautobrm\nState:\tS (sleeping)\nPid:\t42\nVmSize:\t  2048 kB\nVmRSS:\t  1024 kB\n"
+	if err := os.WriteFile(filepath.Join(dir, "42", "status"), []byte(status), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "autobrm.pid"), []byte("42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		db   = newMemStore()
+		ctx  = context.Background()
+		mons = []Monitor{
+			{Name: "autobrm", Pid: filepath.Join(dir, "autobrm.pid"), Proc: dir},
+			{Name: "archiver", Command: "hrd-archiver", Proc: dir},
+		}
+	)
+	for _, m := range mons {
+		if err := NewSampler(db, m, time.Second).Sample(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := Criteria{Period: Period{Starts: time.Now().Add(-time.Minute), Ends: time.Now()}}
+	ps, err := db.FetchProcessHistory(ctx, "autobrm", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 1 {
+		t.Fatalf("unexpected number of samples: want 1, got %d", len(ps))
+	}
+	p := ps[0]
+	if p.Process != "autobrm" || p.Pid != "42" || p.State != "S" || p.RSS != 1<<20 || p.VMSize != 2<<20 {
+		t.Errorf("unexpected sample: %+v", p)
+	}
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLogLines = 100
+	MaxLogLines     = 10000
+	logPoll         = time.Second
+	maxLogErrors    = 5
+	maxLogBlock     = 1 << 20
+)
+
+// logBlock is the size of the first block read from the end of a log. The
+// size doubles for each block read before (up to maxLogBlock).
+var logBlock int64 = 4096
+
+const (
+	fieldFile  = "file"
+	fieldMatch = "match"
+	fieldRegex = "regex"
+)
+
+// layouts tried to parse the timestamp at the start of the lines of a log
+// when the monitor has no layout configured.
+var logLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.000",
+	"2006-01-02 15:04:05",
+	"2006/01/02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+type LogLine struct {
+	File string     `json:"file"`
+	When *time.Time `json:"time,omitempty"`
+	Text string     `json:"text"`
+}
+
+// logQuery selects the lines of a log. A line without timestamp is selected
+// with the time of the last line having one (eg: lines of a stack trace).
+type logQuery struct {
+	Limit   int
+	Match   string
+	Regex   *regexp.Regexp
+	Starts  time.Time
+	Ends    time.Time
+	Layouts []string
+}
+
+func parseLogQuery(r *http.Request, m Monitor) (logQuery, error) {
+	var (
+		q   = logQuery{Layouts: logLayouts}
+		vs  = r.URL.Query()
+		err error
+	)
+	if m.LogTime != "" {
+		q.Layouts = []string{m.LogTime}
+	}
+	if q.Limit, err = parseIntQuery(vs, fieldCount); err != nil {
+		return q, FieldError{Field: fieldCount, Reason: err.Error()}
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultLogLines
+	} else if q.Limit > MaxLogLines {
+		q.Limit = MaxLogLines
+	}
+	q.Match = vs.Get(fieldMatch)
+	if str := vs.Get(fieldRegex); str != "" {
+		if q.Regex, err = regexp.Compile(str); err != nil {
+			return q, FieldError{Field: fieldRegex, Reason: err.Error()}
+		}
+	}
+	if str := vs.Get(fieldStart); str != "" {
+		if q.Starts, err = parseDatetime(str); err != nil {
+			return q, FieldError{Field: fieldStart, Reason: err.Error()}
+		}
+	}
+	if str := vs.Get(fieldEnd); str != "" {
+		if q.Ends, err = parseDatetime(str); err != nil {
+			return q, FieldError{Field: fieldEnd, Reason: err.Error()}
+		}
+	}
+	return q, nil
+}
+
+func (q logQuery) keep(text string, when time.Time) bool {
+	if q.Match != "" && !strings.Contains(text, q.Match) {
+		return false
+	}
+	if q.Regex != nil && !q.Regex.MatchString(text) {
+		return false
+	}
+	if q.Starts.IsZero() && q.Ends.IsZero() {
+		return true
+	}
+	if when.IsZero() {
+		return false
+	}
+	if !q.Starts.IsZero() && when.Before(q.Starts) {
+		return false
+	}
+	return q.Ends.IsZero() || !when.After(q.Ends)
+}
+
+// parseLogTime gives the timestamp at the start of a line. The timestamp can
+// be between brackets and made of one or two words (date and time). A
+// timestamp without zone is given in the local time of otto, the one used by
+// the processes writing their logs on the same host.
+func parseLogTime(text string, layouts []string) (time.Time, bool) {
+	fs := strings.Fields(text)
+	if len(fs) == 0 {
+		return time.Time{}, false
+	}
+	candidates := []string{fs[0]}
+	if len(fs) > 1 {
+		candidates = append(candidates, fs[0]+" "+fs[1])
+	}
+	for _, c := range candidates {
+		c = strings.Trim(c, "[]")
+		for _, layout := range layouts {
+			if when, err := time.ParseInLocation(layout, c, time.Local); err == nil {
+				return when.UTC(), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// logScanner gives the lines of a log selected by its query.
+type logScanner struct {
+	logQuery
+	file string
+	last time.Time
+}
+
+func (s *logScanner) scan(text string) (LogLine, bool) {
+	text = strings.TrimRight(text, "\r\n")
+	line := LogLine{
+		File: filepath.Base(s.file),
+		Text: text,
+	}
+	if when, ok := parseLogTime(text, s.Layouts); ok {
+		s.last = when
+		line.When = &when
+	}
+	return line, s.keep(text, s.last)
+}
+
+// tailLog gives the last lines of file (and of its rotated file if any)
+// selected by q and the offset in file after its last complete line. A line
+// still being written is left to be read later. The logs are read backwards
+// from their end until enough lines are selected or the lines read are older
+// than the period of q.
+func tailLog(file string, q logQuery) ([]LogLine, int64, error) {
+	var (
+		raw    []logText
+		offset int64
+	)
+	for _, f := range []string{file, file + ".1"} {
+		r, err := os.Open(f)
+		if err != nil {
+			if f != file && errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			return nil, 0, err
+		}
+		var done bool
+		end, err := readLinesBackward(r, func(texts []string) bool {
+			prev := make([]logText, 0, len(texts)+len(raw))
+			for _, t := range texts {
+				prev = append(prev, logText{file: f, text: t})
+			}
+			raw = append(prev, raw...)
+			done = q.enough(raw)
+			return done
+		})
+		r.Close()
+		if err != nil {
+			return nil, 0, err
+		}
+		if f == file {
+			offset = end
+		}
+		if done {
+			break
+		}
+	}
+	lines, _ := q.selectLines(raw)
+	return lines, offset, nil
+}
+
+// logText is a complete line read from a log.
+type logText struct {
+	file string
+	text string
+}
+
+// selectLines gives the last lines of raw selected by q and the number of
+// lines selected.
+func (q logQuery) selectLines(raw []logText) ([]LogLine, int) {
+	var (
+		lines = make([]LogLine, 0, q.Limit)
+		count int
+		s     = logScanner{logQuery: q}
+	)
+	for _, r := range raw {
+		s.file = r.file
+		line, ok := s.scan(r.text)
+		if !ok {
+			continue
+		}
+		count++
+		if len(lines) == q.Limit {
+			copy(lines, lines[1:])
+			lines = lines[:len(lines)-1]
+		}
+		lines = append(lines, line)
+	}
+	return lines, count
+}
+
+// enough reports whether the lines before raw can not change the lines
+// selected: the first line has a timestamp (the time of the lines following
+// it without one does not depend on the lines before) and it is older than
+// the period of q or enough lines are already selected.
+func (q logQuery) enough(raw []logText) bool {
+	if len(raw) == 0 {
+		return false
+	}
+	when, ok := parseLogTime(raw[0].text, q.Layouts)
+	if !ok {
+		return false
+	}
+	if !q.Starts.IsZero() && when.Before(q.Starts) {
+		return true
+	}
+	_, n := q.selectLines(raw)
+	return n >= q.Limit
+}
+
+// readLinesBackward reads r by blocks from its end and calls fn with the
+// complete lines of each block until fn returns true or the start of r is
+// reached. It gives the offset in r after its last complete line.
+func readLinesBackward(r *os.File, fn func([]string) bool) (int64, error) {
+	info, err := r.Stat()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		pos   = info.Size()
+		end   = int64(-1)
+		block = logBlock
+		buf   []byte
+	)
+	for pos > 0 {
+		n := block
+		if n > pos {
+			n = pos
+		}
+		pos -= n
+		chunk := make([]byte, n, int64(len(buf))+n)
+		if _, err := r.ReadAt(chunk, pos); err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		buf = append(chunk, buf...)
+		if block < maxLogBlock {
+			block *= 2
+		}
+		if end < 0 {
+			ix := bytes.LastIndexByte(buf, '\n')
+			if ix < 0 {
+				continue
+			}
+			end, buf = pos+int64(ix)+1, buf[:ix+1]
+		}
+		var complete []byte
+		if pos > 0 {
+			ix := bytes.IndexByte(buf, '\n')
+			if ix < 0 || ix == len(buf)-1 {
+				continue
+			}
+			complete, buf = buf[ix+1:], buf[:ix+1]
+		} else {
+			complete, buf = buf, nil
+		}
+		texts := strings.SplitAfter(string(complete), "\n")
+		if fn(texts[:len(texts)-1]) {
+			break
+		}
+	}
+	if end < 0 {
+		end = 0
+	}
+	return end, nil
+}
+
+// readLines calls fn for each line read from r and gives the number of bytes
+// read.
+func readLines(r io.Reader, fn func(string)) (int64, error) {
+	var (
+		rs   = bufio.NewReader(r)
+		size int64
+	)
+	for {
+		text, err := rs.ReadString('\n')
+		size += int64(len(text))
+		if text != "" {
+			fn(text)
+		}
+		if errors.Is(err, io.EOF) {
+			return size, nil
+		}
+		if err != nil {
+			return size, err
+		}
+	}
+}
+
+// logFollower reads the lines appended to a log. It reopens the log when it
+// is rotated and reads it again from its start when it is truncated.
+type logFollower struct {
+	path    string
+	file    *os.File
+	offset  int64
+	partial string
+}
+
+func followLog(path string, offset int64) (*logFollower, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &logFollower{path: path, file: f, offset: offset}, nil
+}
+
+func (f *logFollower) Close() error {
+	return f.file.Close()
+}
+
+func (f *logFollower) next() ([]string, error) {
+	lines, err := f.read()
+	if err != nil {
+		return lines, err
+	}
+	cur, err := f.file.Stat()
+	if err != nil {
+		return lines, err
+	}
+	info, err := os.Stat(f.path)
+	if err != nil {
+		// the log has been moved and not yet created again
+		if errors.Is(err, os.ErrNotExist) {
+			return lines, nil
+		}
+		return lines, err
+	}
+	if !os.SameFile(cur, info) {
+		file, err := os.Open(f.path)
+		if err != nil {
+			return lines, err
+		}
+		f.file.Close()
+		f.file, f.offset, f.partial = file, 0, ""
+		more, err := f.read()
+		return append(lines, more...), err
+	}
+	if info.Size() < f.offset {
+		f.offset, f.partial = 0, ""
+		more, err := f.read()
+		return append(lines, more...), err
+	}
+	return lines, nil
+}
+
+func (f *logFollower) read() ([]string, error) {
+	if _, err := f.file.Seek(f.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var lines []string
+	n, err := readLines(f.file, func(text string) {
+		text = f.partial + text
+		f.partial = ""
+		if !strings.HasSuffix(text, "\n") {
+			f.partial = text
+			return
+		}
+		lines = append(lines, text)
+	})
+	f.offset += n
+	return lines, err
+}
+
+// logFile gives the monitor of the process and the path of the log requested.
+// Without file, the first log of the process is given.
+func logFile(r *http.Request, mons []Monitor) (Monitor, string, error) {
+	var (
+		q    = r.URL.Query()
+		name = q.Get(fieldProcess)
+		file = q.Get(fieldFile)
+	)
+	if name == "" {
+		name = DefaultProcess
+	}
+	for _, m := range mons {
+		if m.Name != name {
+			continue
+		}
+		if len(m.Logs) == 0 {
+			return m, "", fmt.Errorf("%w: no log configured for %s", ErrExist, name)
+		}
+		if file == "" {
+			return m, m.Logs[0], nil
+		}
+		for _, f := range m.Logs {
+			if f == file || filepath.Base(f) == file {
+				return m, f, nil
+			}
+		}
+		return m, "", fmt.Errorf("%w: log %s not found for %s", ErrExist, file, name)
+	}
+	return Monitor{}, "", fmt.Errorf("%w: process %s not found", ErrExist, name)
+}
+
+func listLogs(mons []Monitor) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		m, file, err := logFile(r, mons)
+		if err != nil {
+			return nil, err
+		}
+		q, err := parseLogQuery(r, m)
+		if err != nil {
+			return nil, err
+		}
+		lines, _, err := tailLog(file, q)
+		return lines, err
+	}
+}
+
+// streamLogs sends the last lines of a log then the lines appended to it
+// selected by the query of the request.
+func streamLogs(mons []Monitor) http.Handler {
+	next := func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, fmt.Errorf("%w: streaming not supported", ErrImpl))
+			return
+		}
+		m, file, err := logFile(r, mons)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		q, err := parseLogQuery(r, m)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		lines, offset, err := tailLog(file, q)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		fw, err := followLog(file, offset)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer fw.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		s := logScanner{logQuery: q, file: file}
+		if n := len(lines); n > 0 && lines[n-1].When != nil {
+			s.last = *lines[n-1].When
+		}
+		for _, line := range lines {
+			if err := writeEvent(w, "", "log", line); err != nil {
+				return
+			}
+		}
+		f.Flush()
+
+		tick := time.NewTicker(logPoll)
+		defer tick.Stop()
+		for errs := 0; ; {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-tick.C:
+			}
+			texts, err := fw.next()
+			if err != nil {
+				fmt.Fprintf(w, ": %s\n\n", err)
+				if errs++; errs >= maxLogErrors {
+					f.Flush()
+					return
+				}
+			} else {
+				errs = 0
+			}
+			var sent bool
+			for _, text := range texts {
+				line, ok := s.scan(text)
+				if !ok {
+					continue
+				}
+				if err := writeEvent(w, "", "log", line); err != nil {
+					return
+				}
+				sent = true
+			}
+			if !sent {
+				io.WriteString(w, ":\n\n")
+			}
+			f.Flush()
+		}
+	}
+	return http.HandlerFunc(next)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogTime(t *testing.T) {
+	var (
+		utc   = time.Date(2020, 4, 1, 12, 30, 15, 0, time.UTC)
+		local = time.Date(2020, 4, 1, 12, 30, 15, 0, time.Local)
+	)
+	data := []struct {
+		Line string
+		Want time.Time
+	}{
+		{Line: "2020-04-01T12:30:15Z replay 1 started", Want: utc},
+		{Line: "2020-04-01T14:30:15+02:00 replay 1 started", Want: utc},
+		{Line: "2020-04-01 12:30:15 replay 1 started", Want: local},
+		{Line: "[2020-04-01 12:30:15] replay 1 started", Want: local},
+		{Line: "2020/04/01 12:30:15 replay 1 started", Want: local},
+	}
+	for _, d := range data {
+		when, ok := parseLogTime(d.Line, logLayouts)
+		if !ok || !when.Equal(d.Want) {
+			t.Errorf("%s: unexpected time: %s", d.Line, when)
+		}
+	}
+	if _, ok := parseLogTime("\tat replay.go:42", logLayouts); ok {
+		t.Errorf("time found in line without timestamp")
+	}
+}
+
+func TestTailLog(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		file = filepath.Join(dir, "autobrm.log")
+	)
+	rotated := "2020-04-01 10:00:00 INFO autobrm started\n2020-04-01 10:30:00 INFO replay 1 started\n"
+	current := strings.Join([]string{
+		"2020-04-01 11:00:00 INFO replay 1 completed",
+		"2020-04-01 11:30:00 ERROR replay 2 failed",
+		"\tat replay.go:42",
+		"2020-04-01 12:00:00 INFO replay 3 started",
+		"2020-04-01 12:30",
+	}, "\n")
+	if err := os.WriteFile(file+".1", []byte(rotated), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(current), 0644); err != nil {
+		t.Fatal(err)
+	}
+	at := func(h, m int) time.Time {
+		return time.Date(2020, 4, 1, h, m, 0, 0, time.Local)
+	}
+	data := []struct {
+		Query logQuery
+		Want  []string
+	}{
+		{
+			Query: logQuery{Limit: 2},
+			Want:  []string{"\tat replay.go:42", "2020-04-01 12:00:00 INFO replay 3 started"},
+		},
+		{
+			Query: logQuery{Limit: 10, Match: "replay 1"},
+			Want:  []string{"2020-04-01 10:30:00 INFO replay 1 started", "2020-04-01 11:00:00 INFO replay 1 completed"},
+		},
+		{
+			Query: logQuery{Limit: 10, Regex: regexp.MustCompile(`^\S+ \S+ ERROR`)},
+			Want:  []string{"2020-04-01 11:30:00 ERROR replay 2 failed"},
+		},
+		{
+			Query: logQuery{Limit: 10, Starts: at(11, 15), Ends: at(11, 45)},
+			Want:  []string{"2020-04-01 11:30:00 ERROR replay 2 failed", "\tat replay.go:42"},
+		},
+	}
+	defer func(size int64) {
+		logBlock = size
+	}(logBlock)
+	for _, size := range []int64{logBlock, 16} {
+		logBlock = size
+		for i, d := range data {
+			d.Query.Layouts = logLayouts
+			lines, offset, err := tailLog(file, d.Query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := int64(len(current) - len("2020-04-01 12:30")); offset != want {
+				t.Errorf("%d/%d: unexpected offset: want %d, got %d", size, i, want, offset)
+			}
+			if len(lines) != len(d.Want) {
+				t.Errorf("%d/%d: unexpected number of lines: want %d, got %d", size, i, len(d.Want), len(lines))
+				continue
+			}
+			for j, w := range d.Want {
+				if lines[j].Text != w {
+					t.Errorf("%d/%d: line %d: want %q, got %q", size, i, j, w, lines[j].Text)
+				}
+			}
+		}
+	}
+	if _, _, err := tailLog(filepath.Join(dir, "missing.log"), logQuery{Limit: 10}); err == nil {
+		t.Errorf("expected error for missing log")
+	}
+}
+
+func TestLogFollower(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		file = filepath.Join(dir, "autobrm.log")
+	)
+	write := func(str string, flag int) {
+		t.Helper()
+		f, err := os.OpenFile(file, flag|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(str); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(f *logFollower, want ...string) {
+		t.Helper()
+		lines, err := f.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lines) != len(want) {
+			t.Fatalf("unexpected lines: want %q, got %q", want, lines)
+		}
+		for i := range want {
+			if lines[i] != want[i]+"\n" {
+				t.Errorf("line %d: want %q, got %q", i, want[i], lines[i])
+			}
+		}
+	}
+	write("first\n", os.O_CREATE|os.O_TRUNC)
+	f, err := followLog(file, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	check(f)
+	write("second\nthi", os.O_APPEND)
+	check(f, "second")
+	write("rd\n", os.O_APPEND)
+	check(f, "third")
+
+	write("new\n", os.O_TRUNC)
+	check(f, "new")
+
+	if err := os.Rename(file, file+".1"); err != nil {
+		t.Fatal(err)
+	}
+	file += ".1"
+	write("last of old\n", os.O_APPEND)
+	check(f, "last of old")
+
+	file = strings.TrimSuffix(file, ".1")
+	write("first of new\n", os.O_CREATE|os.O_TRUNC)
+	check(f, "first of new")
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -27,6 +30,16 @@ func (p Period) isValid() bool {
 	return p.Starts.Equal(p.Ends) || p.Starts.Before(p.Ends)
 }
 
+// overlaps checks that p and o share some time. A period ending when the other
+// starts does not overlap it. An empty period overlaps the periods containing
+// its start.
+func (p Period) overlaps(o Period) bool {
+	if p.Starts.Equal(p.Ends) {
+		return !p.Starts.Before(o.Starts) && p.Starts.Before(o.Ends)
+	}
+	return p.Starts.Before(o.Ends) && o.Starts.Before(p.Ends)
+}
+
 type Gap struct {
 	Id        int       `json:"id"`
 	When      time.Time `json:"time"`
@@ -37,6 +50,22 @@ type Gap struct {
 	Period
 }
 
+// MaxSequence is the modulo of the sequence counters of the HRD and VMU
+// packets.
+const MaxSequence = 1 << 32
+
+// missing gives the number of packets lost during the gap, taking into account
+// that the sequence counter can wrap around during the gap. It is computed as
+// by the views of the database so that the detail of a gap gives the same
+// count as the statistics.
+func (g Gap) missing() int64 {
+	return (int64(g.Last) - int64(g.First) + MaxSequence) % MaxSequence
+}
+
+func (g Gap) duration() float64 {
+	return g.Ends.Sub(g.Starts).Seconds()
+}
+
 type HRDGap struct {
 	Gap
 	When    time.Time `json:"time"`
@@ -49,6 +78,24 @@ type VMUGap struct {
 	UPI    string `json:"record"`
 }
 
+type HRDGapDetail struct {
+	HRDGap
+	Missing  int64   `json:"missing"`
+	Duration float64 `json:"duration"`
+	Request  *Replay `json:"request,omitempty"`
+	Previous *HRDGap `json:"previous,omitempty"`
+	Next     *HRDGap `json:"next,omitempty"`
+}
+
+type VMUGapDetail struct {
+	VMUGap
+	Missing  int64   `json:"missing"`
+	Duration float64 `json:"duration"`
+	Request  *Replay `json:"request,omitempty"`
+	Previous *VMUGap `json:"previous,omitempty"`
+	Next     *VMUGap `json:"next,omitempty"`
+}
+
 type RecordInfo struct {
 	UPI   string `json:"record"`
 	Count int    `json:"count"`
@@ -65,13 +112,15 @@ type ChannelInfo struct {
 }
 
 type GapStore interface {
-	FetchRecords() ([]RecordInfo, error)
-	FetchSources() ([]SourceInfo, error)
-	FetchChannels() ([]ChannelInfo, error)
-	FetchGapsHRD(Criteria) (int, []HRDGap, error)
-	FetchGapDetailHRD(int) (HRDGap, error)
-	FetchGapsVMU(Criteria) (int, []VMUGap, error)
-	FetchGapDetailVMU(int) (VMUGap, error)
+	FetchRecords(context.Context) ([]RecordInfo, error)
+	FetchSources(context.Context) ([]SourceInfo, error)
+	FetchChannels(context.Context) ([]ChannelInfo, error)
+	FetchGapsHRD(context.Context, Criteria) (int, []HRDGap, error)
+	FetchGapDetailHRD(context.Context, int) (HRDGapDetail, error)
+	FetchGapsVMU(context.Context, Criteria) (int, []VMUGap, error)
+	FetchGapDetailVMU(context.Context, int) (VMUGapDetail, error)
+	FindGapsHRD(context.Context, Criteria) ([]HRDGap, error)
+	FindGapsVMU(context.Context, Criteria) ([]VMUGap, error)
 }
 
 type Replay struct {
@@ -88,6 +137,51 @@ type Replay struct {
 	Period
 }
 
+type Job struct {
+	When     time.Time `json:"time"`
+	Status   string    `json:"status"`
+	Order    int       `json:"order"`
+	Text     string    `json:"text"`
+	Duration int       `json:"duration"`
+}
+
+type ReplayDetail struct {
+	Replay
+	Jobs      []Job          `json:"jobs"`
+	HRD       []HRDGap       `json:"hrd"`
+	VMU       []VMUGap       `json:"vmu"`
+	Durations map[string]int `json:"durations"`
+}
+
+// computeDurations sets the time spent (in seconds) by the replay in each of
+// its status. The last status is still counting until now if the replay is not
+// completed yet.
+func (r *ReplayDetail) computeDurations(now time.Time) {
+	r.Durations = make(map[string]int)
+	for i := range r.Jobs {
+		end := r.Jobs[i].When
+		if i < len(r.Jobs)-1 {
+			end = r.Jobs[i+1].When
+		} else if r.Cancellable {
+			end = now
+		}
+		if end.After(r.Jobs[i].When) {
+			r.Jobs[i].Duration = int(end.Sub(r.Jobs[i].When).Seconds())
+		}
+		r.Durations[r.Jobs[i].Status] += r.Jobs[i].Duration
+	}
+}
+
+// Overlap tells how the registration of a replay should behave when its
+// period overlaps the period of pending or running replays.
+type Overlap int
+
+const (
+	OverlapReject Overlap = iota
+	OverlapExtend
+	OverlapSplit
+)
+
 type JobStatus struct {
 	When   time.Time `json:"time"`
 	Count  int       `json:"count"`
@@ -102,27 +196,57 @@ type StatusInfo struct {
 }
 
 type ReplayStore interface {
-	FetchStatus() ([]StatusInfo, error)
-	FetchReplayStats(int) ([]JobStatus, error)
-	FetchReplays(Criteria) (int, []Replay, error)
-	FetchReplayDetail(int) (Replay, error)
-	CancelReplay(int, string) (Replay, error)
-	UpdateReplay(int, int) (Replay, error)
-	RegisterReplay(Replay) (Replay, error)
+	FetchStatus(context.Context) ([]StatusInfo, error)
+	FetchReplayStats(context.Context, int) ([]JobStatus, error)
+	FetchReplays(context.Context, Criteria) (int, []Replay, error)
+	FetchReplayDetail(context.Context, int) (ReplayDetail, error)
+	CancelReplay(context.Context, int, string) (Replay, error)
+	UpdateReplay(context.Context, int, int) (Replay, error)
+	RegisterReplay(context.Context, Replay, Overlap) ([]Replay, error)
+	RegisterReplayGaps(context.Context, Replay, Overlap, []GapGroup) ([]Replay, error)
 }
 
 type Variable struct {
 	Id        int      `json:"id"`
 	Name      string   `json:"name"`
 	Value     string   `json:"value"`
+	Type      string   `json:"type"`
 	Range     []string `json:"range"`
 	Hazardous bool     `json:"hazardous"`
 }
 
+type VariableVersion struct {
+	Version int       `json:"version"`
+	When    time.Time `json:"time"`
+	Author  string    `json:"author"`
+	Value   string    `json:"value"`
+}
+
+// PendingChange is a change of an hazardous variable waiting to be confirmed
+// before being applied. Token is only given to the author of the change.
+type PendingChange struct {
+	Id       int       `json:"id"`
+	Variable int       `json:"variable"`
+	When     time.Time `json:"time"`
+	Expires  time.Time `json:"expires"`
+	Author   string    `json:"author"`
+	Value    string    `json:"value"`
+	Token    string    `json:"token,omitempty"`
+}
+
 type ConfigStore interface {
-	FetchVariables() ([]Variable, error)
-	UpdateVariable(int, string) (Variable, error)
-	RegisterVariable(v Variable) (Variable, error)
+	FetchVariables(context.Context) ([]Variable, error)
+	FetchVariable(context.Context, int) (Variable, error)
+	FetchVariableHistory(context.Context, int) ([]VariableVersion, error)
+	UpdateVariable(context.Context, int, string) (Variable, error)
+	RegisterVariable(context.Context, Variable) (Variable, error)
+	DeleteVariable(context.Context, int) (Variable, error)
+
+	FetchPendingChanges(context.Context) ([]PendingChange, error)
+	FetchPendingChange(context.Context, int) (PendingChange, error)
+	RegisterPendingChange(context.Context, PendingChange) (PendingChange, error)
+	ConfirmPendingChange(context.Context, int) (Variable, error)
+	DiscardPendingChange(context.Context, int) (PendingChange, error)
 }
 
 type ItemInfo struct {
@@ -141,57 +265,128 @@ type PacketInfo struct {
 }
 
 type Store interface {
-	Status() (interface{}, error)
-	FetchCounts(int) ([]ItemInfo, error)
-	FetchStatusHRD(int) ([]PacketInfo, error)
+	Status(context.Context) (interface{}, error)
+	FetchCounts(context.Context, int) ([]ItemInfo, error)
+	FetchStatusHRD(context.Context, int) ([]PacketInfo, error)
+	FetchPendingDuration(context.Context) (int, error)
+	FetchPendingAge(context.Context) (int, error)
 
 	GapStore
 	ReplayStore
 	ConfigStore
+	AuditStore
+	EventStore
+	NotifyStore
+	AlertStore
+	ProcessStore
 }
 
 type Handler func(r *http.Request) (interface{}, error)
 
 var (
-	ErrQuery  = errors.New("query")
-	ErrEmpty  = errors.New("empty")
-	ErrIntern = errors.New("internal")
-	ErrExist  = errors.New("exist")
-	ErrImpl   = errors.New("not implemented")
+	ErrQuery     = errors.New("query")
+	ErrEmpty     = errors.New("empty")
+	ErrIntern    = errors.New("internal")
+	ErrExist     = errors.New("exist")
+	ErrConflict  = errors.New("conflict")
+	ErrAuth      = errors.New("unauthorized")
+	ErrForbidden = errors.New("forbidden")
+	ErrImpl      = errors.New("not implemented")
 )
 
+const DefaultQueryTimeout = time.Second * 30
+
+type DBConfig struct {
+	Driver  string
+	Name    string `toml:"database"`
+	Addr    string
+	User    string
+	Passwd  string
+	Timeout int
+}
+
+// QueryTimeout gives the maximum time (configured in seconds) that the queries
+// run to answer a request can take.
+func (c DBConfig) QueryTimeout() time.Duration {
+	if c.Timeout <= 0 {
+		return DefaultQueryTimeout
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+type Config struct {
+	Addr   string
+	Quiet  bool
+	Mon    Monitor      `toml:"autobrm"`
+	Procs  []Monitor    `toml:"process"`
+	DB     DBConfig     `toml:"database"`
+	Auth   AuthConfig   `toml:"auth"`
+	Events EventConfig  `toml:"events"`
+	Notify NotifyConfig `toml:"notify"`
+	Alert  AlertConfig  `toml:"alert"`
+	Health HealthConfig `toml:"health"`
+	Site   struct {
+		Base string `toml:"dir"`
+		URL  string
+	} `toml:"site"`
+}
+
+func loadConfig(file string) (Config, error) {
+	var conf Config
+	return conf, toml.DecodeFile(file, &conf)
+}
+
 func main() {
 	flag.Parse()
 
-	conf := struct {
-		Addr  string
-		Quiet bool
-		Mon   Monitor `toml:"autobrm"`
-		DB    struct {
-			Name   string `toml:"database"`
-			Addr   string
-			User   string
-			Passwd string
-		} `toml:"database"`
-		Site struct {
-			Base string `toml:"dir"`
-			URL  string
-		} `toml:"site"`
-	}{}
-	if err := toml.DecodeFile(flag.Arg(0), &conf); err != nil {
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(flag.Arg(1), flag.Arg(2)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(4)
+		}
+		return
+	}
+
+	conf, err := loadConfig(flag.Arg(0))
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	db, err := setupStore(conf.DB.Addr, conf.DB.User, conf.DB.Passwd, conf.DB.Name, conf.Mon)
+	mons, err := conf.Monitors()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	db, err := setupStore(conf.DB, mons)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(3)
 	}
+	sinks := conf.Notify.Sinks()
+	if len(sinks) > 0 {
+		n := NewNotifier(db, conf.Notify, sinks, conf.DB.QueryTimeout())
+		go n.Run(context.Background())
+	}
+	for _, m := range mons {
+		go NewSampler(db, m, conf.DB.QueryTimeout()).Run(context.Background())
+	}
+	if len(conf.Alert.Rules) > 0 {
+		a, err := NewAlerter(db, mons, conf.Alert, sinks, conf.DB.QueryTimeout())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		go a.Run(context.Background())
+	}
 
-	handler := setupRoutes(db, conf.Site.Base, conf.Site.URL, []string{"*"})
+	handler, err := setupRoutes(db, conf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	if !conf.Quiet {
-		handler = handlers.LoggingHandler(os.Stdout, handler)
+		handler = withoutProbes(handler, handlers.LoggingHandler(os.Stdout, handler))
 	}
 	if err := http.ListenAndServe(conf.Addr, handler); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -199,17 +394,50 @@ func main() {
 	}
 }
 
-func setupRoutes(db Store, site, url string, origins []string) http.Handler {
+func setupRoutes(db Store, conf Config) (http.Handler, error) {
+	auth, err := conf.Auth.Authenticator()
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := conf.Auth.TrustedProxies()
+	if err != nil {
+		return nil, err
+	}
+	mons, err := conf.Monitors()
+	if err != nil {
+		return nil, err
+	}
+	ctl, err := NewController(mons, conf.Auth.ConfirmWindow())
+	if err != nil {
+		return nil, err
+	}
+	if auth == nil && ctl.Configured() {
+		return nil, fmt.Errorf("actions on processes can not be configured without authentication")
+	}
+	hm := newHTTPMetrics()
 	routes := []struct {
-		Do      Handler
-		URL     string
-		Methods []string
+		Do        Handler
+		Raw       http.Handler
+		Accept    string
+		URL       string
+		Methods   []string
+		Role      Role
+		Action    string
+		Failures  bool
+		Before    Handler
+		Timeout   time.Duration
+		Anonymous bool
 	}{
 		{
 			URL:     "/status/",
 			Do:      listStatus(db),
 			Methods: []string{http.MethodGet},
 		},
+		{
+			URL:     "/status/history",
+			Do:      listStatusHistory(db),
+			Methods: []string{http.MethodGet},
+		},
 		{
 			URL:     "/stats/items/",
 			Do:      listItemsStats(db),
@@ -239,16 +467,31 @@ func setupRoutes(db Store, site, url string, origins []string) http.Handler {
 			URL:     "/requests/",
 			Do:      registerRequest(db),
 			Methods: []string{http.MethodPost},
+			Role:    RoleOperator,
+			Action:  "replay.register",
+		},
+		{
+			URL:     "/requests/gaps/",
+			Do:      registerRequestGaps(db),
+			Methods: []string{http.MethodPost},
+			Role:    RoleOperator,
+			Action:  "replay.register",
 		},
 		{
 			URL:     "/requests/{id}",
 			Do:      cancelRequest(db),
 			Methods: []string{http.MethodPost},
+			Role:    RoleOperator,
+			Action:  "replay.cancel",
+			Before:  currentReplay(db),
 		},
 		{
 			URL:     "/requests/{id}",
 			Do:      updateRequest(db),
 			Methods: []string{http.MethodPut},
+			Role:    RoleOperator,
+			Action:  "replay.update",
+			Before:  currentReplay(db),
 		},
 		{
 			URL:     "/requests/{id}",
@@ -290,18 +533,150 @@ func setupRoutes(db Store, site, url string, origins []string) http.Handler {
 			Do:      showGapHRD(db),
 			Methods: []string{http.MethodGet},
 		},
+		{
+			URL:     "/audit/",
+			Do:      listAudits(db),
+			Methods: []string{http.MethodGet},
+			Role:    RoleAdmin,
+		},
 		{
 			URL:     "/config/",
 			Do:      listVariables(db),
 			Methods: []string{http.MethodGet},
 		},
+		{
+			URL:     "/config/",
+			Do:      registerVariable(db),
+			Methods: []string{http.MethodPost},
+			Role:    RoleAdmin,
+			Action:  "variable.register",
+		},
+		{
+			URL:     "/config/pending/",
+			Do:      listPendingChanges(db),
+			Methods: []string{http.MethodGet},
+			Role:    RoleAdmin,
+		},
+		{
+			URL:     "/config/pending/{id}",
+			Do:      confirmPendingChange(db),
+			Methods: []string{http.MethodPost},
+			Role:    RoleAdmin,
+			Action:  "variable.confirm",
+		},
+		{
+			URL:     "/config/pending/{id}",
+			Do:      discardPendingChange(db),
+			Methods: []string{http.MethodDelete},
+			Role:    RoleAdmin,
+			Action:  "variable.discard",
+		},
 		{
 			URL:     "/config/{id}",
-			Do:      updateVariable(db),
+			Do:      updateVariable(db, conf.Auth.ConfirmWindow()),
 			Methods: []string{http.MethodPut},
+			Role:    RoleAdmin,
+			Action:  "variable.update",
+			Before:  currentVariable(db),
+		},
+		{
+			URL:     "/config/{id}",
+			Do:      deleteVariable(db),
+			Methods: []string{http.MethodDelete},
+			Role:    RoleAdmin,
+			Action:  "variable.delete",
+			Before:  currentVariable(db),
+		},
+		{
+			URL:     "/config/{id}/history",
+			Do:      listVariableHistory(db),
+			Methods: []string{http.MethodGet},
+		},
+		{
+			URL:     "/config/{id}/rollback",
+			Do:      rollbackVariable(db, conf.Auth.ConfirmWindow()),
+			Methods: []string{http.MethodPost},
+			Role:    RoleAdmin,
+			Action:  "variable.rollback",
+			Before:  currentVariable(db),
+		},
+		{
+			URL:     "/alerts/",
+			Do:      listAlerts(db),
+			Methods: []string{http.MethodGet},
+		},
+		{
+			URL:     "/notifications/failures/",
+			Do:      listNotifyFailures(db),
+			Methods: []string{http.MethodGet},
+			Role:    RoleAdmin,
+		},
+		{
+			URL:     "/processes/{process}/actions/",
+			Do:      listActions(ctl),
+			Methods: []string{http.MethodGet},
+			Role:    RoleAdmin,
+		},
+		{
+			URL:      "/processes/{process}/actions/{action}",
+			Do:       requestAction(ctl),
+			Methods:  []string{http.MethodPost},
+			Role:     RoleAdmin,
+			Action:   "process.request",
+			Failures: true,
+		},
+		{
+			URL:      "/processes/{process}/actions/{action}/confirm",
+			Do:       confirmAction(ctl),
+			Methods:  []string{http.MethodPost},
+			Role:     RoleAdmin,
+			Action:   "process.control",
+			Failures: true,
+			Timeout:  ctl.Deadline() + conf.DB.QueryTimeout(),
+		},
+		{
+			URL:     "/logs/",
+			Do:      listLogs(mons),
+			Methods: []string{http.MethodGet},
+			Role:    RoleOperator,
+		},
+		{
+			URL:     "/logs/",
+			Raw:     streamLogs(mons),
+			Accept:  "text/event-stream",
+			Methods: []string{http.MethodGet},
+			Role:    RoleOperator,
+		},
+		{
+			URL:     "/events/",
+			Raw:     streamEvents(db, conf.Events.PollInterval(), conf.DB.QueryTimeout()),
+			Accept:  "text/event-stream",
+			Methods: []string{http.MethodGet},
+		},
+		{
+			URL:       pathLive,
+			Raw:       checkLive(time.Now()),
+			Methods:   []string{http.MethodGet, http.MethodHead},
+			Anonymous: true,
+		},
+		{
+			URL:       pathReady,
+			Raw:       checkReady(db, mons, conf.Health.Deadline()),
+			Methods:   []string{http.MethodGet, http.MethodHead},
+			Anonymous: true,
+		},
+		{
+			URL:     "/metrics",
+			Raw:     exportMetrics(db, mons, hm, conf.DB.QueryTimeout()),
+			Methods: []string{http.MethodGet},
 		},
 	}
-	r := mux.NewRouter()
+	var (
+		r       = mux.NewRouter()
+		site    = conf.Site.Base
+		url     = conf.Site.URL
+		origins = []string{"*"}
+	)
 	if site != "" {
 		if url == "" {
 			url = "/"
@@ -311,7 +686,29 @@ func setupRoutes(db Store, site, url string, origins []string) http.Handler {
 		r.PathPrefix("/js/").Handler(http.StripPrefix("/js/", http.FileServer(http.Dir(filepath.Join(site, "js")))))
 	}
 	for _, route := range routes {
-		next := wrapHandler(route.Do)
+		if route.Raw != nil {
+			next := route.Raw
+			if auth != nil && !route.Anonymous && !(conf.Auth.Public && route.Role == RoleViewer) {
+				next = authorizeStream(auth, route.Role, next)
+			}
+			h := r.Handle(route.URL, next).Methods(route.Methods...)
+			if route.Accept != "" {
+				h.Headers("Accept", route.Accept)
+			}
+			continue
+		}
+		do := route.Do
+		if route.Action != "" {
+			do = audit(db, proxies, route.Action, route.Failures, route.Before, do)
+		}
+		if auth != nil && !(conf.Auth.Public && route.Role == RoleViewer && isReadOnly(route.Methods)) {
+			do = authorize(auth, route.Role, do)
+		}
+		timeout := conf.DB.QueryTimeout()
+		if route.Timeout > timeout {
+			timeout = route.Timeout
+		}
+		next := hm.instrument(route.URL, wrapHandler(do, timeout))
 		r.Handle(route.URL, next).Methods(route.Methods...).Headers("Accept", "application/json")
 	}
 	methods := []string{
@@ -321,41 +718,55 @@ func setupRoutes(db Store, site, url string, origins []string) http.Handler {
 		http.MethodPut,
 		http.MethodPost,
 	}
-	return handlers.CORS(handlers.AllowedOrigins(origins), handlers.AllowedMethods(methods))(r)
+	var (
+		headers = []string{"Authorization", "Content-Type"}
+		cors    = handlers.CORS(handlers.AllowedOrigins(origins), handlers.AllowedMethods(methods), handlers.AllowedHeaders(headers))
+	)
+	return cors(r), nil
 }
 
-func setupStore(addr, user, passwd, name string, mon Monitor) (Store, error) {
-	if mon.Proc == "" {
-		mon.Proc = "/proc"
-	} else {
-		mon.Proc = filepath.Clean(mon.Proc)
+func isReadOnly(methods []string) bool {
+	for _, m := range methods {
+		if m != http.MethodGet && m != http.MethodHead {
+			return false
+		}
+	}
+	return true
+}
+
+func setupStore(c DBConfig, mons []Monitor) (Store, error) {
+	if len(mons) == 0 {
+		return nil, fmt.Errorf("no process to monitor")
+	}
+	switch c.Driver {
+	case "", DriverMySQL:
+		return NewDBStore(c.Addr, c.Name, c.User, c.Passwd, mons)
+	case DriverSQLite:
+		return NewSQLiteStore(c.Name, mons)
+	default:
+		return nil, fmt.Errorf("%s: unsupported driver", c.Driver)
 	}
-	return NewDBStore(addr, name, user, passwd, mon)
 }
 
-func wrapHandler(do Handler) http.Handler {
+func openDB(c DBConfig) (*sql.DB, error) {
+	switch c.Driver {
+	case "", DriverMySQL:
+		return openMySQL(c.Addr, c.Name, c.User, c.Passwd)
+	case DriverSQLite:
+		return openSQLite(c.Name)
+	default:
+		return nil, fmt.Errorf("%s: unsupported driver", c.Driver)
+	}
+}
+
+func wrapHandler(do Handler, timeout time.Duration) http.Handler {
 	next := func(w http.ResponseWriter, r *http.Request) {
-		data, err := do(r)
-		if err != nil {
-			code := http.StatusInternalServerError
-			switch {
-			case errors.Is(err, ErrQuery):
-				code = http.StatusBadRequest
-			case errors.Is(err, ErrIntern):
-			case errors.Is(err, ErrExist):
-				code = http.StatusNotFound
-			case errors.Is(err, ErrEmpty):
-				code = http.StatusNoContent
-			case errors.Is(err, ErrImpl):
-				code = http.StatusNotImplemented
-			}
-			w.WriteHeader(code)
-			c := struct {
-				Err string `json:"err"`
-			}{
-				Err: err.Error(),
-			}
-			json.NewEncoder(w).Encode(c)
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		data, err := do(r.WithContext(ctx))
+		if err != nil {
+			writeError(w, err)
 			return
 		}
 		code := http.StatusOK
@@ -372,9 +783,64 @@ func wrapHandler(do Handler) http.Handler {
 	return http.HandlerFunc(next)
 }
 
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		code = http.StatusGatewayTimeout
+	case errors.Is(err, ErrQuery):
+		code = http.StatusBadRequest
+	case errors.Is(err, ErrAuth):
+		code = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="otto"`)
+	case errors.Is(err, ErrForbidden):
+		code = http.StatusForbidden
+	case errors.Is(err, ErrIntern):
+	case errors.Is(err, ErrExist):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		code = http.StatusConflict
+	case errors.Is(err, ErrEmpty):
+		code = http.StatusNoContent
+	case errors.Is(err, ErrImpl):
+		code = http.StatusNotImplemented
+	}
+	w.WriteHeader(code)
+	c := struct {
+		Err    string         `json:"err"`
+		Field  string         `json:"field,omitempty"`
+		Result *ControlResult `json:"result,omitempty"`
+	}{
+		Err: err.Error(),
+	}
+	var fe FieldError
+	if errors.As(err, &fe) {
+		c.Field = fe.Field
+	}
+	var ce ControlError
+	if errors.As(err, &ce) {
+		c.Result = &ce.Result
+	}
+	json.NewEncoder(w).Encode(c)
+}
+
 func listStatus(db Store) Handler {
 	return func(r *http.Request) (interface{}, error) {
-		return db.Status()
+		return db.Status(r.Context())
+	}
+}
+
+func listStatusHistory(db ProcessStore) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		query, err := FromRequest(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		process := r.URL.Query().Get(fieldProcess)
+		if process == "" {
+			process = DefaultProcess
+		}
+		return db.FetchProcessHistory(r.Context(), process, query)
 	}
 }
 
@@ -384,7 +850,7 @@ func listItemsStats(db Store) Handler {
 		if err != nil {
 			return nil, err
 		}
-		return db.FetchCounts(days)
+		return db.FetchCounts(r.Context(), days)
 	}
 }
 
@@ -394,7 +860,7 @@ func listStatsHRD(db Store) Handler {
 		if err != nil {
 			return nil, err
 		}
-		return db.FetchStatusHRD(days)
+		return db.FetchStatusHRD(r.Context(), days)
 	}
 }
 
@@ -404,7 +870,7 @@ func listRequests(db ReplayStore) Handler {
 		if err != nil {
 			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
 		}
-		count, rs, err := db.FetchReplays(query)
+		count, rs, err := db.FetchReplays(r.Context(), query)
 		if err != nil {
 			return nil, err
 		}
@@ -425,13 +891,13 @@ func listRequestsStats(db ReplayStore) Handler {
 		if err != nil {
 			return nil, err
 		}
-		return db.FetchReplayStats(days)
+		return db.FetchReplayStats(r.Context(), days)
 	}
 }
 
 func listRegisteredStatus(db ReplayStore) Handler {
 	return func(r *http.Request) (interface{}, error) {
-		return db.FetchStatus()
+		return db.FetchStatus(r.Context())
 	}
 }
 
@@ -441,7 +907,7 @@ func showRequest(db ReplayStore) Handler {
 		if err != nil {
 			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
 		}
-		return db.FetchReplayDetail(id)
+		return db.FetchReplayDetail(r.Context(), id)
 	}
 }
 
@@ -457,7 +923,7 @@ func updateRequest(db ReplayStore) Handler {
 		if err := parseBody(r, &v); err != nil {
 			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
 		}
-		return db.UpdateReplay(id, v.Priority)
+		return db.UpdateReplay(r.Context(), id, v.Priority)
 	}
 }
 
@@ -473,17 +939,87 @@ func cancelRequest(db ReplayStore) Handler {
 		if err := parseBody(r, &c); err != nil {
 			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
 		}
-		return db.CancelReplay(id, c.Comment)
+		return db.CancelReplay(r.Context(), id, c.Comment)
 	}
 }
 
 func registerRequest(db ReplayStore) Handler {
 	return func(r *http.Request) (interface{}, error) {
+		mode, err := parseOverlap(r.URL.Query().Get(fieldOverlap))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
 		var rp Replay
 		if err := parseBody(r, &rp); err != nil {
 			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
 		}
-		return db.RegisterReplay(rp)
+		return db.RegisterReplay(r.Context(), rp, mode)
+	}
+}
+
+func registerRequestGaps(db Store) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		v := struct {
+			HRD      []int  `json:"hrd"`
+			VMU      []int  `json:"vmu"`
+			Padding  int    `json:"padding"`
+			Merge    int    `json:"merge"`
+			Priority int    `json:"priority"`
+			Comment  string `json:"comment"`
+		}{}
+		if err := parseBody(r, &v); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		mode, err := parseOverlap(r.URL.Query().Get(fieldOverlap))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		var (
+			hrd []HRDGap
+			vmu []VMUGap
+		)
+		if len(v.HRD) == 0 && len(v.VMU) == 0 {
+			query, err := FromRequest(r)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+			}
+			if query.Record == "" && query.Source == "" {
+				if hrd, err = db.FindGapsHRD(r.Context(), query); err != nil {
+					return nil, err
+				}
+			}
+			if query.Channel == "" {
+				if vmu, err = db.FindGapsVMU(r.Context(), query); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for _, id := range v.HRD {
+			g, err := db.FetchGapDetailHRD(r.Context(), id)
+			if err != nil {
+				return nil, err
+			}
+			hrd = append(hrd, g.HRDGap)
+		}
+		for _, id := range v.VMU {
+			g, err := db.FetchGapDetailVMU(r.Context(), id)
+			if err != nil {
+				return nil, err
+			}
+			vmu = append(vmu, g.VMUGap)
+		}
+		if len(hrd) == 0 && len(vmu) == 0 {
+			return nil, fmt.Errorf("%w: no gaps selected", ErrQuery)
+		}
+		var (
+			pad   = time.Duration(v.Padding) * time.Second
+			merge = time.Duration(v.Merge) * time.Second
+			rp    = Replay{
+				Priority: v.Priority,
+				Comment:  v.Comment,
+			}
+		)
+		return db.RegisterReplayGaps(r.Context(), rp, mode, groupGaps(hrd, vmu, pad, merge))
 	}
 }
 
@@ -493,7 +1029,7 @@ func listGapsVMU(db GapStore) Handler {
 		if err != nil {
 			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
 		}
-		count, rs, err := db.FetchGapsVMU(query)
+		count, rs, err := db.FetchGapsVMU(r.Context(), query)
 		if err != nil {
 			return nil, err
 		}
@@ -512,21 +1048,21 @@ func showGapVMU(db GapStore) Handler {
 	return func(r *http.Request) (interface{}, error) {
 		id, err := parseInt(r, fieldId)
 		if err != nil {
-			return nil, fmt.Errorf("%w: %s", ErrQuery)
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
 		}
-		return db.FetchGapDetailVMU(id)
+		return db.FetchGapDetailVMU(r.Context(), id)
 	}
 }
 
 func listRecordsVMU(db GapStore) Handler {
 	return func(r *http.Request) (interface{}, error) {
-		return db.FetchRecords()
+		return db.FetchRecords(r.Context())
 	}
 }
 
 func listSourcesVMU(db GapStore) Handler {
 	return func(r *http.Request) (interface{}, error) {
-		return db.FetchSources()
+		return db.FetchSources(r.Context())
 	}
 }
 
@@ -536,7 +1072,10 @@ func listGapsHRD(db GapStore) Handler {
 		if err != nil {
 			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
 		}
-		count, rs, err := db.FetchGapsHRD(query)
+		count, rs, err := db.FetchGapsHRD(r.Context(), query)
+		if err != nil {
+			return nil, err
+		}
 		c := struct {
 			Count  int      `json:"total"`
 			Result []HRDGap `json:"data"`
@@ -550,7 +1089,7 @@ func listGapsHRD(db GapStore) Handler {
 
 func listChannelsHRD(db GapStore) Handler {
 	return func(r *http.Request) (interface{}, error) {
-		return db.FetchChannels()
+		return db.FetchChannels(r.Context())
 	}
 }
 
@@ -558,23 +1097,86 @@ func showGapHRD(db GapStore) Handler {
 	return func(r *http.Request) (interface{}, error) {
 		id, err := parseInt(r, fieldId)
 		if err != nil {
-			return nil, fmt.Errorf("%w: %s", ErrQuery)
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		return db.FetchGapDetailHRD(r.Context(), id)
+	}
+}
+
+func listAudits(db AuditStore) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		query, err := FromRequest(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		count, rs, err := db.FetchAudits(r.Context(), query)
+		if err != nil {
+			return nil, err
+		}
+		c := struct {
+			Count  int     `json:"total"`
+			Result []Audit `json:"data"`
+		}{
+			Count:  count,
+			Result: rs,
+		}
+		return c, nil
+	}
+}
+
+func listAlerts(db AlertStore) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		query, err := FromRequest(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		count, rs, err := db.FetchAlerts(r.Context(), query)
+		if err != nil {
+			return nil, err
+		}
+		c := struct {
+			Count  int     `json:"total"`
+			Result []Alert `json:"data"`
+		}{
+			Count:  count,
+			Result: rs,
 		}
-		return db.FetchGapDetailHRD(id)
+		return c, nil
+	}
+}
+
+func listNotifyFailures(db NotifyStore) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		query, err := FromRequest(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		count, rs, err := db.FetchNotifyFailures(r.Context(), query)
+		if err != nil {
+			return nil, err
+		}
+		c := struct {
+			Count  int             `json:"total"`
+			Result []NotifyFailure `json:"data"`
+		}{
+			Count:  count,
+			Result: rs,
+		}
+		return c, nil
 	}
 }
 
 func listVariables(db ConfigStore) Handler {
 	return func(r *http.Request) (interface{}, error) {
-		return db.FetchVariables()
+		return db.FetchVariables(r.Context())
 	}
 }
 
-func updateVariable(db ConfigStore) Handler {
+func updateVariable(db ConfigStore, window time.Duration) Handler {
 	return func(r *http.Request) (interface{}, error) {
 		id, err := parseInt(r, fieldId)
 		if err != nil {
-			return nil, fmt.Errorf("%w: %s", ErrQuery)
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
 		}
 		v := struct {
 			Value string `json:"value"`
@@ -582,7 +1184,115 @@ func updateVariable(db ConfigStore) Handler {
 		if err := parseBody(r, &v); err != nil {
 			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
 		}
-		return db.UpdateVariable(id, v.Value)
+		return changeVariable(r.Context(), db, id, v.Value, window)
+	}
+}
+
+func listVariableHistory(db ConfigStore) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		id, err := parseInt(r, fieldId)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		return db.FetchVariableHistory(r.Context(), id)
+	}
+}
+
+func rollbackVariable(db ConfigStore, window time.Duration) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		id, err := parseInt(r, fieldId)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		v := struct {
+			Version int `json:"version"`
+		}{}
+		if err := parseBody(r, &v); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		vs, err := db.FetchVariableHistory(r.Context(), id)
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range vs {
+			if x.Version == v.Version {
+				return changeVariable(r.Context(), db, id, x.Value, window)
+			}
+		}
+		return nil, fmt.Errorf("%w: version %d not found for variable %d", ErrExist, v.Version, id)
+	}
+}
+
+// changeVariable validates value and updates the variable. The change of an
+// hazardous variable is only registered and should be confirmed before being
+// applied.
+func changeVariable(ctx context.Context, db ConfigStore, id int, value string, window time.Duration) (interface{}, error) {
+	v, err := db.FetchVariable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.Validate(value); err != nil {
+		return nil, err
+	}
+	if !v.Hazardous {
+		return db.UpdateVariable(ctx, id, value)
+	}
+	p, err := newPendingChange(ctx, v, value, window)
+	if err != nil {
+		return nil, err
+	}
+	return db.RegisterPendingChange(ctx, p)
+}
+
+func listPendingChanges(db ConfigStore) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		ps, err := db.FetchPendingChanges(r.Context())
+		for i := range ps {
+			ps[i].Token = ""
+		}
+		return ps, err
+	}
+}
+
+func confirmPendingChange(db ConfigStore) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		id, err := parseInt(r, fieldId)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		c := struct {
+			Token string `json:"token"`
+		}{}
+		if err := parseBody(r, &c); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		p, err := db.FetchPendingChange(r.Context(), id)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.canConfirm(r.Context(), c.Token); err != nil {
+			return nil, err
+		}
+		v, err := db.FetchVariable(r.Context(), p.Variable)
+		if err != nil {
+			return nil, err
+		}
+		if err := v.Validate(p.Value); err != nil {
+			return nil, err
+		}
+		return db.ConfirmPendingChange(r.Context(), id)
+	}
+}
+
+func discardPendingChange(db ConfigStore) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		id, err := parseInt(r, fieldId)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		p, err := db.DiscardPendingChange(r.Context(), id)
+		p.Token = ""
+		return p, err
 	}
 }
 
@@ -592,6 +1302,29 @@ func registerVariable(db ConfigStore) Handler {
 		if err := parseBody(r, &v); err != nil {
 			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
 		}
-		return db.RegisterVariable(v)
+		if v.Type == "" {
+			v.Type = TypeString
+		}
+		if err := v.ValidateDefinition(); err != nil {
+			return nil, err
+		}
+		return db.RegisterVariable(r.Context(), v)
+	}
+}
+
+func deleteVariable(db ConfigStore) Handler {
+	return func(r *http.Request) (interface{}, error) {
+		id, err := parseInt(r, fieldId)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuery, err)
+		}
+		v, err := db.FetchVariable(r.Context(), id)
+		if err != nil {
+			return nil, err
+		}
+		if v.Hazardous {
+			return nil, fmt.Errorf("%w: hazardous variable %s can not be deleted", ErrForbidden, v.Name)
+		}
+		return db.DeleteVariable(r.Context(), id)
 	}
 }
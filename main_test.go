@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoutes(t *testing.T) {
+	data := []struct {
+		Method string
+		URL    string
+		Body   string
+		Code   int
+	}{
+		{Method: http.MethodGet, URL: "/status/", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/status/history?dtstart=2020-04-01T00:00:00Z", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/status/history?dtend=foobar", Code: http.StatusBadRequest},
+		{Method: http.MethodGet, URL: "/stats/items/?days=7", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/stats/packets/", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/stats/requests/", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/requests/", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/requests/?dtstart=foobar", Code: http.StatusBadRequest},
+		{Method: http.MethodGet, URL: "/requests/status/", Code: http.StatusOK},
+		{
+			Method: http.MethodPost,
+			URL:    "/requests/",
+			Body:   `{"dtstart": "2020-04-02T10:00:00Z", "dtend": "2020-04-02T11:00:00Z", "priority": 1}`,
+			Code:   http.StatusCreated,
+		},
+		{
+			Method: http.MethodPost,
+			URL:    "/requests/",
+			Body:   `{"dtstart": "2020-04-02T11:00:00Z", "dtend": "2020-04-02T10:00:00Z"}`,
+			Code:   http.StatusBadRequest,
+		},
+		{
+			Method: http.MethodPost,
+			URL:    "/requests/",
+			Body:   `{"dtstart": "2020-04-01T10:00:00Z", "dtend": "2020-04-01T11:00:00Z"}`,
+			Code:   http.StatusCreated,
+		},
+		{
+			Method: http.MethodPost,
+			URL:    "/requests/",
+			Body:   `{"dtstart": "2020-04-01T11:30:00Z", "dtend": "2020-04-01T12:30:00Z"}`,
+			Code:   http.StatusConflict,
+		},
+		{
+			Method: http.MethodPost,
+			URL:    "/requests/?overlap=split",
+			Body:   `{"dtstart": "2020-04-01T11:30:00Z", "dtend": "2020-04-01T12:30:00Z"}`,
+			Code:   http.StatusCreated,
+		},
+		{
+			Method: http.MethodPost,
+			URL:    "/requests/?overlap=foobar",
+			Body:   `{"dtstart": "2020-04-01T11:30:00Z", "dtend": "2020-04-01T12:30:00Z"}`,
+			Code:   http.StatusBadRequest,
+		},
+		{Method: http.MethodPost, URL: "/requests/", Body: `{`, Code: http.StatusBadRequest},
+		{Method: http.MethodPost, URL: "/requests/gaps/?overlap=extend", Body: `{"hrd": [1], "vmu": [1], "padding": 60}`, Code: http.StatusCreated},
+		{Method: http.MethodPost, URL: "/requests/gaps/", Body: `{"hrd": [99]}`, Code: http.StatusNotFound},
+		{Method: http.MethodPost, URL: "/requests/1", Body: `{"comment": "cancel"}`, Code: http.StatusCreated},
+		{Method: http.MethodPost, URL: "/requests/99", Body: `{"comment": "cancel"}`, Code: http.StatusNotFound},
+		{Method: http.MethodPut, URL: "/requests/1", Body: `{"priority": 10}`, Code: http.StatusOK},
+		{Method: http.MethodPut, URL: "/requests/foobar", Body: `{"priority": 10}`, Code: http.StatusBadRequest},
+		{Method: http.MethodGet, URL: "/requests/1", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/requests/99", Code: http.StatusNotFound},
+		{Method: http.MethodGet, URL: "/archives/vmu/gaps/", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/archives/vmu/records/", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/archives/vmu/sources/", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/archives/vmu/gaps/1", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/archives/vmu/gaps/99", Code: http.StatusNotFound},
+		{Method: http.MethodGet, URL: "/archives/vmu/gaps/foobar", Code: http.StatusBadRequest},
+		{Method: http.MethodGet, URL: "/archives/hrd/gaps/", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/archives/hrd/channels/", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/archives/hrd/gaps/1", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/archives/hrd/gaps/99", Code: http.StatusNotFound},
+		{Method: http.MethodGet, URL: "/config/", Code: http.StatusOK},
+		{Method: http.MethodPut, URL: "/config/1", Body: `{"value": "10"}`, Code: http.StatusOK},
+		{Method: http.MethodPut, URL: "/config/99", Body: `{"value": "10"}`, Code: http.StatusNotFound},
+		{Method: http.MethodPut, URL: "/config/1", Body: `{"value": "abc"}`, Code: http.StatusBadRequest},
+		{Method: http.MethodPut, URL: "/config/1", Body: `{"value": "400"}`, Code: http.StatusBadRequest},
+		{Method: http.MethodGet, URL: "/config/1/history", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/config/99/history", Code: http.StatusNotFound},
+		{Method: http.MethodPost, URL: "/config/1/rollback", Body: `{"version": 1}`, Code: http.StatusCreated},
+		{Method: http.MethodPost, URL: "/config/1/rollback", Body: `{"version": 99}`, Code: http.StatusNotFound},
+		{Method: http.MethodPost, URL: "/config/", Body: `{"name": "replay_padding", "type": "duration", "value": "30s", "range": ["0s", "5m"]}`, Code: http.StatusCreated},
+		{Method: http.MethodPost, URL: "/config/", Body: `{"name": "api_days_back", "type": "int", "value": "10"}`, Code: http.StatusConflict},
+		{Method: http.MethodPost, URL: "/config/", Body: `{"name": "replay_padding", "type": "duration", "value": "1h", "range": ["0s", "5m"]}`, Code: http.StatusBadRequest},
+		{Method: http.MethodPost, URL: "/config/", Body: `{"name": "", "value": "10"}`, Code: http.StatusBadRequest},
+		{Method: http.MethodDelete, URL: "/config/1", Code: http.StatusOK},
+		{Method: http.MethodDelete, URL: "/config/2", Code: http.StatusForbidden},
+		{Method: http.MethodDelete, URL: "/config/99", Code: http.StatusNotFound},
+		{Method: http.MethodGet, URL: "/config/pending/", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/alerts/", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/alerts/?status=firing", Code: http.StatusOK},
+		{Method: http.MethodPost, URL: "/config/pending/99", Code: http.StatusNotFound},
+		{Method: http.MethodDelete, URL: "/config/pending/99", Code: http.StatusNotFound},
+		{Method: http.MethodGet, URL: "/processes/autobrm/actions/", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/logs/", Code: http.StatusNotFound},
+		{Method: http.MethodGet, URL: "/logs/?process=archiver", Code: http.StatusNotFound},
+		{Method: http.MethodGet, URL: "/processes/archiver/actions/", Code: http.StatusNotFound},
+		{Method: http.MethodPost, URL: "/processes/autobrm/actions/stop", Code: http.StatusNotFound},
+		{Method: http.MethodPost, URL: "/processes/autobrm/actions/stop/confirm", Body: `{"token": "abc"}`, Code: http.StatusNotFound},
+	}
+	handler, err := setupRoutes(newMemStore(), Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range data {
+		code := serveRequest(handler, d.Method, d.URL, d.Body)
+		if code != d.Code {
+			t.Errorf("%s %s: unexpected status code: want %d, got %d", d.Method, d.URL, d.Code, code)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	data := []struct {
+		Err  error
+		Code int
+	}{
+		{Err: ErrQuery, Code: http.StatusBadRequest},
+		{Err: ErrExist, Code: http.StatusNotFound},
+		{Err: ErrEmpty, Code: http.StatusNoContent},
+		{Err: ErrImpl, Code: http.StatusNotImplemented},
+		{Err: ErrIntern, Code: http.StatusInternalServerError},
+		{Err: ErrConflict, Code: http.StatusConflict},
+		{Err: ErrAuth, Code: http.StatusUnauthorized},
+		{Err: ErrForbidden, Code: http.StatusForbidden},
+		{Err: context.DeadlineExceeded, Code: http.StatusGatewayTimeout},
+		{Err: errors.New("unknown"), Code: http.StatusInternalServerError},
+	}
+	urls := []string{
+		"/status/",
+		"/requests/",
+		"/requests/1",
+		"/archives/hrd/gaps/",
+		"/archives/hrd/gaps/1",
+		"/archives/vmu/gaps/",
+		"/archives/vmu/gaps/1",
+		"/config/",
+	}
+	for _, d := range data {
+		db := newMemStore()
+		db.err = d.Err
+
+		handler, err := setupRoutes(db, Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range urls {
+			code := serveRequest(handler, http.MethodGet, u, "")
+			if code != d.Code {
+				t.Errorf("GET %s (%s): unexpected status code: want %d, got %d", u, d.Err, d.Code, code)
+			}
+		}
+	}
+}
+
+func serveRequest(handler http.Handler, method, url, body string) int {
+	return serve(handler, newRequest(method, url, body))
+}
+
+func newRequest(method, url, body string) *http.Request {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Accept", "application/json")
+	return req
+}
+
+func serve(handler http.Handler, req *http.Request) int {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestRollbackVariable(t *testing.T) {
+	db := newMemStore()
+	handler, err := setupRoutes(db, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := serveRequest(handler, http.MethodPut, "/config/1", `{"value": "10"}`); code != http.StatusOK {
+		t.Fatalf("update: unexpected status code: want %d, got %d", http.StatusOK, code)
+	}
+	if code := serveRequest(handler, http.MethodPost, "/config/1/rollback", `{"version": 1}`); code != http.StatusCreated {
+		t.Fatalf("rollback: unexpected status code: want %d, got %d", http.StatusCreated, code)
+	}
+	if v := db.variables[0]; v.Value != "15" {
+		t.Errorf("unexpected value after rollback: want 15, got %s", v.Value)
+	}
+	if n := len(db.history[1]); n != 3 {
+		t.Errorf("unexpected number of versions: want 3, got %d", n)
+	}
+}
+
+func TestConfirmVariable(t *testing.T) {
+	var conf Config
+	conf.Auth.Tokens = []TokenConfig{
+		{User: "alice", Token: "aaaa"},
+		{User: "bob", Token: "bbbb"},
+	}
+	conf.Auth.Admins = []string{"alice", "bob"}
+
+	db := newMemStore()
+	handler, err := setupRoutes(db, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	as := func(token string) func(*http.Request) {
+		return func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	request := func(method, url, body string, auth func(*http.Request), want int) *httptest.ResponseRecorder {
+		t.Helper()
+		req := newRequest(method, url, body)
+		auth(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("%s %s: unexpected status code: want %d, got %d", method, url, want, rec.Code)
+		}
+		return rec
+	}
+
+	request(http.MethodPut, "/config/2", `{"value": "unknown"}`, as("aaaa"), http.StatusBadRequest)
+	request(http.MethodPut, "/config/2", `{"value": "manual"}`, as("aaaa"), http.StatusOK)
+	if v := db.variables[1]; v.Value != "auto" {
+		t.Fatalf("hazardous variable changed before confirmation: %s", v.Value)
+	}
+	rec := request(http.MethodGet, "/config/pending/", "", as("bbbb"), http.StatusOK)
+	var ps []PendingChange
+	if err := json.NewDecoder(rec.Body).Decode(&ps); err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 1 || ps[0].Token != "" {
+		t.Fatalf("unexpected pending changes: %+v", ps)
+	}
+	request(http.MethodPost, "/config/pending/1", "", as("aaaa"), http.StatusForbidden)
+	request(http.MethodPost, "/config/pending/1", `{"token": "foobar"}`, as("aaaa"), http.StatusForbidden)
+	request(http.MethodPost, "/config/pending/1", "", as("bbbb"), http.StatusCreated)
+	if v := db.variables[1]; v.Value != "manual" {
+		t.Errorf("unexpected value after confirmation: want manual, got %s", v.Value)
+	}
+	request(http.MethodPost, "/config/pending/1", "", as("bbbb"), http.StatusNotFound)
+
+	rec = request(http.MethodPut, "/config/2", `{"value": "auto"}`, as("aaaa"), http.StatusOK)
+	var p PendingChange
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	db.pending[0].Expires = time.Now().Add(-time.Second)
+	request(http.MethodPost, "/config/pending/2", `{"token": "`+p.Token+`"}`, as("aaaa"), http.StatusForbidden)
+	db.pending[0].Expires = time.Now().Add(time.Minute)
+	request(http.MethodPost, "/config/pending/2", `{"token": "`+p.Token+`"}`, as("aaaa"), http.StatusCreated)
+	if v := db.variables[1]; v.Value != "auto" {
+		t.Errorf("unexpected value after confirmation: want auto, got %s", v.Value)
+	}
+
+	request(http.MethodPut, "/config/2", `{"value": "manual"}`, as("aaaa"), http.StatusOK)
+	request(http.MethodDelete, "/config/pending/3", "", as("bbbb"), http.StatusOK)
+	if len(db.pending) != 0 {
+		t.Errorf("pending change not discarded")
+	}
+}
+
+func TestRegisterRequestGaps(t *testing.T) {
+	db := newMemStore()
+	db.seedGapHRD(HRDGap{
+		Gap: Gap{
+			First:  30,
+			Last:   40,
+			Period: Period{Starts: time.Date(2020, 4, 1, 8, 0, 0, 0, time.UTC), Ends: time.Date(2020, 4, 1, 8, 1, 0, 0, time.UTC)},
+		},
+		Channel: "vic1",
+	})
+	handler, err := setupRoutes(db, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := serveRequest(handler, http.MethodPost, "/requests/gaps/", `{"hrd": [1, 2]}`); code != http.StatusConflict {
+		t.Fatalf("reject: unexpected status code: want %d, got %d", http.StatusConflict, code)
+	}
+	if n := len(db.replays); n != 1 {
+		t.Fatalf("replays registered despite conflict: want 1, got %d", n)
+	}
+	if code := serveRequest(handler, http.MethodPost, "/requests/gaps/?overlap=split", `{"hrd": [1, 2], "merge": 36000}`); code != http.StatusCreated {
+		t.Fatalf("split: unexpected status code: want %d, got %d", http.StatusCreated, code)
+	}
+	if n := len(db.replays); n != 2 {
+		t.Fatalf("unexpected number of replays: want 2, got %d", n)
+	}
+	if g := db.hrd[0]; g.Replay != 1 {
+		t.Errorf("gap outside of the new replay linked to it: want 1, got %d", g.Replay)
+	}
+	if g := db.hrd[1]; g.Replay != 2 {
+		t.Errorf("gap not linked to the new replay: want 2, got %d", g.Replay)
+	}
+}
+
+func TestGapMissing(t *testing.T) {
+	data := []struct {
+		First int
+		Last  int
+		Want  int64
+	}{
+		{First: 10, Last: 15, Want: 5},
+		{First: 10, Last: 10, Want: 0},
+		{First: 4294967290, Last: 5, Want: 11},
+		{First: 4294967295, Last: 0, Want: 1},
+	}
+	for _, d := range data {
+		g := Gap{First: d.First, Last: d.Last}
+		if got := g.missing(); got != d.Want {
+			t.Errorf("%d-%d: unexpected missing packets: want %d, got %d", d.First, d.Last, d.Want, got)
+		}
+	}
+}
@@ -0,0 +1,848 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// memStore is an in memory implementation of Store used by the tests. All its
+// methods return err when it is set.
+type memStore struct {
+	mu sync.Mutex
+
+	replays   []Replay
+	jobs      map[int][]Job
+	hrd       []HRDGap
+	vmu       []VMUGap
+	variables []Variable
+	status    []StatusInfo
+	audits    []Audit
+	history   map[int][]VariableVersion
+	pending   []PendingChange
+	journal   []Event
+	failures  []NotifyFailure
+	alerts    []Alert
+	samples   []ProcessSample
+	changes   int
+
+	err error
+}
+
+func newMemStore() *memStore {
+	var (
+		now = time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+		s   = memStore{
+			jobs:    make(map[int][]Job),
+			history: make(map[int][]VariableVersion),
+		}
+	)
+	s.status = []StatusInfo{
+		{Id: 1, Name: "pending", Order: 1},
+		{Id: 2, Name: "running", Order: 2},
+		{Id: 3, Name: "completed", Order: 3},
+		{Id: 4, Name: "cancelled", Order: 4},
+	}
+	s.seedReplay(Replay{
+		Priority: 1,
+		Period:   Period{Starts: now.Add(-time.Hour), Ends: now},
+	})
+	s.seedGapHRD(HRDGap{
+		Gap: Gap{
+			First:  10,
+			Last:   20,
+			Replay: 1,
+			Period: Period{Starts: now.Add(-time.Minute * 30), Ends: now.Add(-time.Minute * 29)},
+		},
+		Channel: "vic1",
+	})
+	s.seedGapVMU(VMUGap{
+		Gap: Gap{
+			First:  100,
+			Last:   110,
+			Replay: 1,
+			Period: Period{Starts: now.Add(-time.Minute * 20), Ends: now.Add(-time.Minute * 19)},
+		},
+		Source: 1,
+		UPI:    "SCIENCE",
+	})
+	s.variables = []Variable{
+		{Id: 1, Name: "api_days_back", Value: "15", Type: TypeInt, Range: []string{"1", "365"}},
+		{Id: 2, Name: "autobrm_mode", Value: "auto", Type: TypeEnum, Range: []string{"auto", "manual"}, Hazardous: true},
+	}
+	for _, v := range s.variables {
+		s.registerVersion(v, "migration")
+	}
+	return &s
+}
+
+func (s *memStore) seedReplay(r Replay) Replay {
+	r.Id = len(s.replays) + 1
+	if r.When.IsZero() {
+		r.When = r.Ends
+	}
+	r.Status = s.status[0].Name
+	r.Cancellable = true
+	s.replays = append(s.replays, r)
+	s.appendJob(r.Id, Job{When: r.When, Status: r.Status, Order: s.status[0].Order})
+	return r
+}
+
+func (s *memStore) seedGapHRD(g HRDGap) {
+	g.Id = len(s.hrd) + 1
+	g.When = g.Ends
+	s.hrd = append(s.hrd, g)
+}
+
+func (s *memStore) seedGapVMU(g VMUGap) {
+	g.Id = len(s.vmu) + 1
+	g.When = g.Ends
+	s.vmu = append(s.vmu, g)
+}
+
+func (s *memStore) Status(_ context.Context) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := map[string]interface{}{
+		"requests": map[string]interface{}{
+			"count": len(s.replays),
+		},
+		"hrd": map[string]interface{}{
+			"count": len(s.hrd),
+		},
+		"vmu": map[string]interface{}{
+			"count": len(s.vmu),
+		},
+	}
+	return status, s.err
+}
+
+func (s *memStore) FetchCounts(_ context.Context, days int) ([]ItemInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vs := []ItemInfo{
+		{Label: "REPLAY", Origin: "ALL", Count: len(s.replays)},
+		{Label: "HRD", Origin: "ALL", Count: len(s.hrd)},
+		{Label: "VMU", Origin: "ALL", Count: len(s.vmu)},
+	}
+	return vs, s.err
+}
+
+func (s *memStore) FetchStatusHRD(_ context.Context, days int) ([]PacketInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var vs []PacketInfo
+	for _, g := range s.hrd {
+		i := PacketInfo{
+			Label:   "MISSING",
+			When:    g.When,
+			Channel: g.Channel,
+			Count:   int(g.missing()),
+		}
+		vs = append(vs, i)
+	}
+	return vs, s.err
+}
+
+func (s *memStore) FetchRecords(_ context.Context) ([]RecordInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int)
+	for _, g := range s.vmu {
+		counts[g.UPI]++
+	}
+	var vs []RecordInfo
+	for k, c := range counts {
+		vs = append(vs, RecordInfo{UPI: k, Count: c})
+	}
+	return vs, s.err
+}
+
+func (s *memStore) FetchSources(_ context.Context) ([]SourceInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[int]int)
+	for _, g := range s.vmu {
+		counts[g.Source]++
+	}
+	var vs []SourceInfo
+	for k, c := range counts {
+		vs = append(vs, SourceInfo{Source: k, Count: c})
+	}
+	return vs, s.err
+}
+
+func (s *memStore) FetchChannels(_ context.Context) ([]ChannelInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int)
+	for _, g := range s.hrd {
+		counts[g.Channel]++
+	}
+	var vs []ChannelInfo
+	for k, c := range counts {
+		vs = append(vs, ChannelInfo{Channel: k, Count: c})
+	}
+	return vs, s.err
+}
+
+func (s *memStore) FetchGapsHRD(_ context.Context, c Criteria) (int, []HRDGap, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var vs []HRDGap
+	for _, g := range s.hrd {
+		if c.Channel != "" && c.Channel != g.Channel {
+			continue
+		}
+		vs = append(vs, g)
+	}
+	return len(vs), vs, s.err
+}
+
+func (s *memStore) FindGapsHRD(ctx context.Context, c Criteria) ([]HRDGap, error) {
+	_, vs, err := s.FetchGapsHRD(ctx, c)
+	return vs, err
+}
+
+func (s *memStore) FetchGapDetailHRD(_ context.Context, id int) (HRDGapDetail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var g HRDGapDetail
+	if s.err != nil {
+		return g, s.err
+	}
+	if id <= 0 || id > len(s.hrd) {
+		return g, fmt.Errorf("%w: hrd gap %d not found", ErrExist, id)
+	}
+	g.HRDGap = s.hrd[id-1]
+	g.Missing = g.missing()
+	g.Duration = g.duration()
+	g.Request = s.findReplay(g.Replay)
+	return g, nil
+}
+
+func (s *memStore) FetchGapsVMU(_ context.Context, c Criteria) (int, []VMUGap, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var vs []VMUGap
+	for _, g := range s.vmu {
+		if c.Record != "" && c.Record != g.UPI {
+			continue
+		}
+		vs = append(vs, g)
+	}
+	return len(vs), vs, s.err
+}
+
+func (s *memStore) FindGapsVMU(ctx context.Context, c Criteria) ([]VMUGap, error) {
+	_, vs, err := s.FetchGapsVMU(ctx, c)
+	return vs, err
+}
+
+func (s *memStore) FetchGapDetailVMU(_ context.Context, id int) (VMUGapDetail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var g VMUGapDetail
+	if s.err != nil {
+		return g, s.err
+	}
+	if id <= 0 || id > len(s.vmu) {
+		return g, fmt.Errorf("%w: vmu gap %d not found", ErrExist, id)
+	}
+	g.VMUGap = s.vmu[id-1]
+	g.Missing = g.missing()
+	g.Duration = g.duration()
+	g.Request = s.findReplay(g.Replay)
+	return g, nil
+}
+
+func (s *memStore) FetchStatus(_ context.Context) ([]StatusInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vs := make([]StatusInfo, len(s.status))
+	copy(vs, s.status)
+	for i := range vs {
+		for _, r := range s.replays {
+			if r.Status == vs[i].Name {
+				vs[i].Count++
+			}
+		}
+	}
+	return vs, s.err
+}
+
+func (s *memStore) FetchReplayStats(_ context.Context, days int) ([]JobStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var vs []JobStatus
+	for _, r := range s.replays {
+		j := JobStatus{
+			When:   r.When,
+			Status: r.Status,
+			Count:  1,
+		}
+		vs = append(vs, j)
+	}
+	return vs, s.err
+}
+
+func (s *memStore) FetchReplays(_ context.Context, c Criteria) (int, []Replay, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var vs []Replay
+	for _, r := range s.replays {
+		if c.Status != "" && c.Status != r.Status {
+			continue
+		}
+		vs = append(vs, r)
+	}
+	return len(vs), vs, s.err
+}
+
+func (s *memStore) FetchReplayDetail(_ context.Context, id int) (ReplayDetail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var r ReplayDetail
+	if s.err != nil {
+		return r, s.err
+	}
+	rp := s.findReplay(id)
+	if rp == nil {
+		return r, fmt.Errorf("%w: replay %d not found", ErrExist, id)
+	}
+	r.Replay = *rp
+	r.Jobs = append(r.Jobs, s.jobs[id]...)
+	for _, g := range s.hrd {
+		if g.Replay == id {
+			r.HRD = append(r.HRD, g)
+		}
+	}
+	for _, g := range s.vmu {
+		if g.Replay == id {
+			r.VMU = append(r.VMU, g)
+		}
+	}
+	r.computeDurations(time.Now().UTC())
+	return r, nil
+}
+
+func (s *memStore) CancelReplay(_ context.Context, id int, comment string) (Replay, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return Replay{}, s.err
+	}
+	r := s.findReplay(id)
+	if r == nil {
+		return Replay{}, fmt.Errorf("%w: replay %d not found", ErrExist, id)
+	}
+	if !r.Cancellable {
+		return *r, fmt.Errorf("%w: replay job already cancelled", ErrQuery)
+	}
+	s.updateStatus(r, s.status[len(s.status)-1], comment)
+	return *r, nil
+}
+
+func (s *memStore) UpdateReplay(_ context.Context, id, priority int) (Replay, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return Replay{}, s.err
+	}
+	r := s.findReplay(id)
+	if r == nil {
+		return Replay{}, fmt.Errorf("%w: replay %d not found", ErrExist, id)
+	}
+	r.Priority = priority
+	return *r, nil
+}
+
+func (s *memStore) RegisterReplay(ctx context.Context, r Replay, mode Overlap) ([]Replay, error) {
+	return s.RegisterReplayGaps(ctx, r, mode, []GapGroup{{Period: r.Period}})
+}
+
+func (s *memStore) RegisterReplayGaps(_ context.Context, r Replay, mode Overlap, gs []GapGroup) ([]Replay, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var (
+		replays = append([]Replay(nil), s.replays...)
+		hrd     = append([]HRDGap(nil), s.hrd...)
+		vmu     = append([]VMUGap(nil), s.vmu...)
+		jobs    = make(map[int][]Job)
+		journal = len(s.journal)
+		rs      []Replay
+	)
+	for k, v := range s.jobs {
+		jobs[k] = v
+	}
+	for _, g := range gs {
+		r.Period = g.Period
+		xs, err := s.registerGroup(r, mode, g)
+		if err != nil {
+			s.replays, s.hrd, s.vmu, s.jobs, s.journal = replays, hrd, vmu, jobs, s.journal[:journal]
+			return nil, err
+		}
+		rs = append(rs, xs...)
+	}
+	return rs, nil
+}
+
+func (s *memStore) registerGroup(r Replay, mode Overlap, g GapGroup) ([]Replay, error) {
+	if !r.isValid() {
+		return nil, fmt.Errorf("%w: invalid period", ErrQuery)
+	}
+	var (
+		others []Replay
+		ps     []Period
+	)
+	for _, o := range s.replays {
+		if !o.Cancellable || !r.overlaps(o.Period) {
+			continue
+		}
+		others = append(others, o)
+		ps = append(ps, o.Period)
+	}
+	if len(others) == 0 {
+		ps = []Period{r.Period}
+	} else {
+		switch mode {
+		case OverlapSplit:
+			if ps = subtractPeriods(r.Period, ps); len(ps) == 0 {
+				return nil, conflictReplays(others)
+			}
+		case OverlapExtend:
+			keep := s.findReplay(others[0].Id)
+			for _, o := range others {
+				if o.Status != s.status[0].Name {
+					return nil, conflictReplays(others)
+				}
+				if o.Starts.Before(r.Starts) {
+					r.Starts = o.Starts
+				}
+				if o.Ends.After(r.Ends) {
+					r.Ends = o.Ends
+				}
+			}
+			keep.Period = r.Period
+			for _, o := range others[1:] {
+				s.updateStatus(s.findReplay(o.Id), s.status[len(s.status)-1], fmt.Sprintf("merged into replay %d", keep.Id))
+			}
+			hrd, vmu := g.within(g.Period)
+			s.linkGaps(keep.Id, hrd, vmu)
+			return []Replay{*keep}, nil
+		default:
+			return nil, conflictReplays(others)
+		}
+	}
+	var rs []Replay
+	for _, p := range ps {
+		r.Period = p
+		r.When = time.Now().UTC()
+		x := s.seedReplay(r)
+		hrd, vmu := g.within(p)
+		s.linkGaps(x.Id, hrd, vmu)
+		rs = append(rs, x)
+	}
+	return rs, nil
+}
+
+func (s *memStore) FetchVariables(_ context.Context) ([]Variable, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vs := make([]Variable, len(s.variables))
+	copy(vs, s.variables)
+	return vs, s.err
+}
+
+func (s *memStore) FetchVariable(_ context.Context, id int) (Variable, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return Variable{}, s.err
+	}
+	for _, v := range s.variables {
+		if v.Id == id {
+			return v, nil
+		}
+	}
+	return Variable{}, fmt.Errorf("%w: variable %d not found", ErrExist, id)
+}
+
+func (s *memStore) UpdateVariable(ctx context.Context, id int, value string) (Variable, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return Variable{}, s.err
+	}
+	for i := range s.variables {
+		if s.variables[i].Id == id {
+			s.variables[i].Value = value
+			s.registerVersion(s.variables[i], actorFromContext(ctx))
+			return s.variables[i], nil
+		}
+	}
+	return Variable{}, fmt.Errorf("%w: variable %d not found", ErrExist, id)
+}
+
+func (s *memStore) FetchVariableHistory(_ context.Context, id int) ([]VariableVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	vs, ok := s.history[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: variable %d not found", ErrExist, id)
+	}
+	xs := make([]VariableVersion, 0, len(vs))
+	for i := len(vs) - 1; i >= 0; i-- {
+		xs = append(xs, vs[i])
+	}
+	return xs, nil
+}
+
+func (s *memStore) registerVersion(v Variable, author string) {
+	var count int
+	for _, vs := range s.history {
+		count += len(vs)
+	}
+	x := VariableVersion{
+		Version: count + 1,
+		When:    time.Now().UTC(),
+		Author:  author,
+		Value:   v.Value,
+	}
+	s.history[v.Id] = append(s.history[v.Id], x)
+}
+
+func (s *memStore) RegisterVariable(ctx context.Context, v Variable) (Variable, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return v, s.err
+	}
+	v.Id = 1
+	for _, x := range s.variables {
+		if x.Name == v.Name {
+			return v, fmt.Errorf("%w: variable %s already exists (%d)", ErrConflict, v.Name, x.Id)
+		}
+		if x.Id >= v.Id {
+			v.Id = x.Id + 1
+		}
+	}
+	s.variables = append(s.variables, v)
+	s.registerVersion(v, actorFromContext(ctx))
+	return v, nil
+}
+
+func (s *memStore) DeleteVariable(_ context.Context, id int) (Variable, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return Variable{}, s.err
+	}
+	for i, v := range s.variables {
+		if v.Id == id {
+			s.variables = append(s.variables[:i], s.variables[i+1:]...)
+			delete(s.history, id)
+			return v, nil
+		}
+	}
+	return Variable{}, fmt.Errorf("%w: variable %d not found", ErrExist, id)
+}
+
+func (s *memStore) FetchPendingChanges(_ context.Context) ([]PendingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps := make([]PendingChange, len(s.pending))
+	copy(ps, s.pending)
+	return ps, s.err
+}
+
+func (s *memStore) FetchPendingChange(_ context.Context, id int) (PendingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return PendingChange{}, s.err
+	}
+	i, err := s.indexPendingChange(id)
+	if err != nil {
+		return PendingChange{}, err
+	}
+	return s.pending[i], nil
+}
+
+func (s *memStore) RegisterPendingChange(_ context.Context, p PendingChange) (PendingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return p, s.err
+	}
+	s.changes++
+	p.Id = s.changes
+	s.pending = append(s.pending, p)
+	return p, nil
+}
+
+func (s *memStore) ConfirmPendingChange(ctx context.Context, id int) (Variable, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return Variable{}, s.err
+	}
+	i, err := s.indexPendingChange(id)
+	if err != nil {
+		return Variable{}, err
+	}
+	p := s.pending[i]
+	s.pending = append(s.pending[:i], s.pending[i+1:]...)
+	for i := range s.variables {
+		if s.variables[i].Id == p.Variable {
+			s.variables[i].Value = p.Value
+			s.registerVersion(s.variables[i], actorFromContext(ctx))
+			return s.variables[i], nil
+		}
+	}
+	return Variable{}, fmt.Errorf("%w: variable %d not found", ErrExist, p.Variable)
+}
+
+func (s *memStore) DiscardPendingChange(_ context.Context, id int) (PendingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return PendingChange{}, s.err
+	}
+	i, err := s.indexPendingChange(id)
+	if err != nil {
+		return PendingChange{}, err
+	}
+	p := s.pending[i]
+	s.pending = append(s.pending[:i], s.pending[i+1:]...)
+	return p, nil
+}
+
+func (s *memStore) indexPendingChange(id int) (int, error) {
+	for i, p := range s.pending {
+		if p.Id == id {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: pending change %d not found", ErrExist, id)
+}
+
+func (s *memStore) FetchEventCursor(_ context.Context) (EventCursor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := EventCursor{
+		Job: len(s.journal),
+		HRD: len(s.hrd),
+		VMU: len(s.vmu),
+	}
+	for _, vs := range s.history {
+		c.Variable += len(vs)
+	}
+	return c, s.err
+}
+
+func (s *memStore) FetchEvents(_ context.Context, c EventCursor) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var es []Event
+	for _, e := range s.journal {
+		if e.Id > c.Job {
+			es = append(es, e)
+		}
+	}
+	for _, g := range s.hrd {
+		if g.Id > c.HRD {
+			es = append(es, Event{Id: g.Id, Type: EventGapHRD, When: g.When, Data: g})
+		}
+	}
+	for _, g := range s.vmu {
+		if g.Id > c.VMU {
+			es = append(es, Event{Id: g.Id, Type: EventGapVMU, When: g.When, Data: g})
+		}
+	}
+	for id, vs := range s.history {
+		for _, v := range vs {
+			if v.Version > c.Variable {
+				x := VariableEvent{Variable: id, VariableVersion: v}
+				es = append(es, Event{Id: v.Version, Type: EventVariable, When: v.When, Data: x})
+			}
+		}
+	}
+	return sortEvents(c, es), nil
+}
+
+func (s *memStore) RegisterNotifyFailure(_ context.Context, f NotifyFailure) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f.Id = len(s.failures) + 1
+	s.failures = append(s.failures, f)
+	return s.err
+}
+
+func (s *memStore) FetchNotifyFailures(_ context.Context, _ Criteria) (int, []NotifyFailure, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fs := make([]NotifyFailure, len(s.failures))
+	copy(fs, s.failures)
+	return len(fs), fs, s.err
+}
+
+func (s *memStore) FetchPendingAge(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var d time.Duration
+	for _, r := range s.replays {
+		if age := time.Since(r.When); r.Status == StatusPending && age > d {
+			d = age
+		}
+	}
+	return int(d.Seconds()), s.err
+}
+
+func (s *memStore) FetchPendingDuration(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var d time.Duration
+	for _, r := range s.replays {
+		if r.Status == StatusPending {
+			d += r.Ends.Sub(r.Starts)
+		}
+	}
+	return int(d.Seconds()), s.err
+}
+
+func (s *memStore) FetchAlerts(_ context.Context, c Criteria) (int, []Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var as []Alert
+	for _, a := range s.alerts {
+		if c.Status == "" || c.Status == a.State {
+			as = append(as, a)
+		}
+	}
+	return len(as), as, s.err
+}
+
+func (s *memStore) RegisterAlert(_ context.Context, a Alert) (Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return a, s.err
+	}
+	a.Id = len(s.alerts) + 1
+	s.alerts = append(s.alerts, a)
+	return a, nil
+}
+
+func (s *memStore) ResolveAlert(_ context.Context, a Alert) (Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return a, s.err
+	}
+	if a.Id <= 0 || a.Id > len(s.alerts) {
+		return a, fmt.Errorf("%w: alert %d not found", ErrExist, a.Id)
+	}
+	s.alerts[a.Id-1] = a
+	return a, nil
+}
+
+func (s *memStore) RegisterAudit(_ context.Context, a Audit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a.Id = len(s.audits) + 1
+	s.audits = append(s.audits, a)
+	return nil
+}
+
+func (s *memStore) FetchAudits(_ context.Context, c Criteria) (int, []Audit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var vs []Audit
+	for _, a := range s.audits {
+		if a.When.Before(c.Starts) || a.When.After(c.Ends) {
+			continue
+		}
+		vs = append(vs, a)
+	}
+	return len(vs), vs, s.err
+}
+
+func (s *memStore) findReplay(id int) *Replay {
+	for i := range s.replays {
+		if s.replays[i].Id == id {
+			return &s.replays[i]
+		}
+	}
+	return nil
+}
+
+func (s *memStore) updateStatus(r *Replay, status StatusInfo, comment string) {
+	r.Status = status.Name
+	r.Comment = comment
+	r.Cancellable = false
+	j := Job{
+		When:   time.Now().UTC(),
+		Status: status.Name,
+		Order:  status.Order,
+		Text:   comment,
+	}
+	s.appendJob(r.Id, j)
+}
+
+func (s *memStore) appendJob(replay int, j Job) {
+	s.jobs[replay] = append(s.jobs[replay], j)
+	e := Event{
+		Id:   len(s.journal) + 1,
+		Type: EventReplay,
+		When: j.When,
+		Data: ReplayEvent{Replay: replay, Job: j},
+	}
+	s.journal = append(s.journal, e)
+}
+
+func (s *memStore) linkGaps(replay int, hrd, vmu []int) {
+	for _, id := range hrd {
+		if id > 0 && id <= len(s.hrd) {
+			s.hrd[id-1].Replay = replay
+		}
+	}
+	for _, id := range vmu {
+		if id > 0 && id <= len(s.vmu) {
+			s.vmu[id-1].Replay = replay
+		}
+	}
+}
+
+func (s *memStore) RegisterProcessSample(_ context.Context, p ProcessSample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.samples = append(s.samples, p)
+	return nil
+}
+
+func (s *memStore) FetchProcessHistory(_ context.Context, process string, c Criteria) ([]ProcessSample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ps []ProcessSample
+	for _, p := range s.samples {
+		if p.Process != process || p.When.Before(c.Starts) || p.When.After(c.Ends) {
+			continue
+		}
+		ps = append(ps, p)
+	}
+	return ps, s.err
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buckets (in seconds) of the histogram of the HTTP requests latency.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type routeKey struct {
+	Route  string
+	Method string
+}
+
+type routeStats struct {
+	codes   map[int]uint64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// httpMetrics counts the requests handled by each route of the route table
+// of setupRoutes and measures their latency.
+type httpMetrics struct {
+	mu     sync.Mutex
+	routes map[routeKey]*routeStats
+}
+
+func newHTTPMetrics() *httpMetrics {
+	return &httpMetrics{routes: make(map[routeKey]*routeStats)}
+}
+
+func (m *httpMetrics) instrument(route string, next http.Handler) http.Handler {
+	do := func(w http.ResponseWriter, r *http.Request) {
+		var (
+			now = time.Now()
+			rw  = statusWriter{ResponseWriter: w, code: http.StatusOK}
+		)
+		next.ServeHTTP(&rw, r)
+		m.observe(routeKey{Route: route, Method: r.Method}, rw.code, time.Since(now))
+	}
+	return http.HandlerFunc(do)
+}
+
+func (m *httpMetrics) observe(k routeKey, code int, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.routes[k]
+	if !ok {
+		s = &routeStats{
+			codes:   make(map[int]uint64),
+			buckets: make([]uint64, len(latencyBuckets)),
+		}
+		m.routes[k] = s
+	}
+	secs := elapsed.Seconds()
+	s.codes[code]++
+	s.sum += secs
+	s.count++
+	for i, b := range latencyBuckets {
+		if secs <= b {
+			s.buckets[i]++
+		}
+	}
+}
+
+func (m *httpMetrics) writeTo(w *metricWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]routeKey, 0, len(m.routes))
+	for k := range m.routes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Route == keys[j].Route {
+			return keys[i].Method < keys[j].Method
+		}
+		return keys[i].Route < keys[j].Route
+	})
+
+	w.header("otto_http_requests_total", "counter", "Number of HTTP requests handled per route, method and status code.")
+	for _, k := range keys {
+		s := m.routes[k]
+		codes := make([]int, 0, len(s.codes))
+		for c := range s.codes {
+			codes = append(codes, c)
+		}
+		sort.Ints(codes)
+		for _, c := range codes {
+			w.sample("otto_http_requests_total", float64(s.codes[c]), "route", k.Route, "method", k.Method, "code", strconv.Itoa(c))
+		}
+	}
+	w.header("otto_http_request_duration_seconds", "histogram", "Latency of the HTTP requests per route and method.")
+	for _, k := range keys {
+		s := m.routes[k]
+		for i, b := range latencyBuckets {
+			le := strconv.FormatFloat(b, 'f', -1, 64)
+			w.sample("otto_http_request_duration_seconds_bucket", float64(s.buckets[i]), "route", k.Route, "method", k.Method, "le", le)
+		}
+		w.sample("otto_http_request_duration_seconds_bucket", float64(s.count), "route", k.Route, "method", k.Method, "le", "+Inf")
+		w.sample("otto_http_request_duration_seconds_sum", s.sum, "route", k.Route, "method", k.Method)
+		w.sample("otto_http_request_duration_seconds_count", float64(s.count), "route", k.Route, "method", k.Method)
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// metricWriter writes metrics in the Prometheus text exposition format.
+type metricWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *metricWriter) header(name, kind, help string) {
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes the value of name with the given labels given as pairs of
+// label name and value.
+func (w *metricWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	w.printf("%s %s\n", b.String(), strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func (w *metricWriter) printf(pattern string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, pattern, args...)
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(str string) string {
+	return labelReplacer.Replace(str)
+}
+
+// parseMemory gives the number of bytes of a size read from /proc/<pid>/status
+// (eg: 1024 kB).
+func parseMemory(str string) (float64, bool) {
+	fs := strings.Fields(str)
+	if len(fs) == 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(fs[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	if len(fs) > 1 {
+		switch strings.ToLower(fs[1]) {
+		case "kb":
+			n *= 1 << 10
+		case "mb":
+			n *= 1 << 20
+		case "gb":
+			n *= 1 << 30
+		}
+	}
+	return n, true
+}
+
+func exportMetrics(db Store, mons []Monitor, hm *httpMetrics, timeout time.Duration) http.Handler {
+	do := func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w := metricWriter{w: rw}
+		hm.writeTo(&w)
+		if s, ok := db.(interface{ Stats() sql.DBStats }); ok {
+			writeDBStats(&w, s.Stats())
+		}
+		if err := writeStoreMetrics(ctx, &w, db); err != nil {
+			fmt.Fprintf(os.Stderr, "fail to collect metrics: %s\n", err)
+		}
+		writeProcessMetrics(&w, mons)
+	}
+	return http.HandlerFunc(do)
+}
+
+func writeDBStats(w *metricWriter, s sql.DBStats) {
+	w.header("otto_db_open_connections", "gauge", "Number of established connections to the database.")
+	w.sample("otto_db_open_connections", float64(s.OpenConnections))
+	w.header("otto_db_in_use_connections", "gauge", "Number of connections currently in use.")
+	w.sample("otto_db_in_use_connections", float64(s.InUse))
+	w.header("otto_db_idle_connections", "gauge", "Number of idle connections.")
+	w.sample("otto_db_idle_connections", float64(s.Idle))
+	w.header("otto_db_max_open_connections", "gauge", "Maximum number of open connections to the database.")
+	w.sample("otto_db_max_open_connections", float64(s.MaxOpenConnections))
+	w.header("otto_db_wait_count_total", "counter", "Number of connections waited for.")
+	w.sample("otto_db_wait_count_total", float64(s.WaitCount))
+	w.header("otto_db_wait_duration_seconds_total", "counter", "Time blocked waiting for a new connection.")
+	w.sample("otto_db_wait_duration_seconds_total", s.WaitDuration.Seconds())
+}
+
+// writeStoreMetrics writes the metrics computed from the database. It keeps
+// going when one of them fails and gives the errors met.
+func writeStoreMetrics(ctx context.Context, w *metricWriter, db Store) error {
+	var errs []string
+	failed := func(what string, err error) bool {
+		if err == nil || errors.Is(err, ErrEmpty) {
+			return false
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", what, err))
+		return true
+	}
+	if cs, err := db.FetchChannels(ctx); !failed("channels", err) {
+		w.header("otto_hrd_gaps", "gauge", "Number of HRD gaps per channel.")
+		for _, c := range cs {
+			w.sample("otto_hrd_gaps", float64(c.Count), "channel", c.Channel)
+		}
+	}
+	if ss, err := db.FetchSources(ctx); !failed("sources", err) {
+		w.header("otto_vmu_gaps", "gauge", "Number of VMU gaps per source.")
+		for _, s := range ss {
+			w.sample("otto_vmu_gaps", float64(s.Count), "source", strconv.Itoa(s.Source))
+		}
+	}
+	if ss, err := db.FetchStatus(ctx); !failed("status", err) {
+		w.header("otto_replays", "gauge", "Number of replays per status.")
+		for _, s := range ss {
+			w.sample("otto_replays", float64(s.Count), "status", s.Name)
+		}
+	}
+	if d, err := db.FetchPendingAge(ctx); !failed("pending", err) {
+		w.header("otto_replay_pending_seconds", "gauge", "Time since the oldest replay still pending has been requested.")
+		w.sample("otto_replay_pending_seconds", float64(d))
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// processMetric is a metric read from the status of a process. Its type is
+// gauge unless another one is given.
+type processMetric struct {
+	Name  string
+	Type  string
+	Help  string
+	Value func(map[string]string) (float64, bool)
+}
+
+var processMetrics = []processMetric{
+	{Name: "otto_process_up", Help: "Whether the monitored process is running.", Value: processUp},
+	{Name: "otto_process_resident_memory_bytes", Help: "Resident memory size of the process.", Value: processMemory("vmrss")},
+	{Name: "otto_process_virtual_memory_bytes", Help: "Virtual memory size of the process.", Value: processMemory("vmsize")},
+	{Name: "otto_process_cpu_percent", Help: "CPU usage of the process over the last sampling window.", Value: processField("cpu")},
+	{Name: "otto_process_threads", Help: "Number of threads of the process.", Value: processField("threads")},
+	{Name: "otto_process_open_fds", Help: "Number of files opened by the process.", Value: processField("fds")},
+	{Name: "otto_process_uptime_seconds", Help: "Time elapsed since the process started.", Value: processField("uptime")},
+	{Name: "otto_process_read_bytes_total", Type: "counter", Help: "Number of bytes read from storage by the process.", Value: processField("read_bytes")},
+	{Name: "otto_process_written_bytes_total", Type: "counter", Help: "Number of bytes written to storage by the process.", Value: processField("write_bytes")},
+	{Name: "otto_process_stale_pidfile", Help: "Whether the pidfile of the process refers to another or no process.", Value: processStale},
+}
+
+func processUp(status map[string]string) (float64, bool) {
+	if isRunning(status) {
+		return 1, true
+	}
+	return 0, true
+}
+
+func processStale(status map[string]string) (float64, bool) {
+	switch status["stale"] {
+	case "true":
+		return 1, true
+	case "false":
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+func processMemory(field string) func(map[string]string) (float64, bool) {
+	return func(status map[string]string) (float64, bool) {
+		return parseMemory(status[field])
+	}
+}
+
+func processField(field string) func(map[string]string) (float64, bool) {
+	return func(status map[string]string) (float64, bool) {
+		n, err := strconv.ParseFloat(status[field], 64)
+		return n, err == nil
+	}
+}
+
+// writeProcessMetrics writes the metrics of each monitored process labeled
+// with the name of the process.
+func writeProcessMetrics(w *metricWriter, mons []Monitor) {
+	status := make([]map[string]string, len(mons))
+	for i, m := range mons {
+		status[i] = m.readProcess()
+	}
+	for _, pm := range processMetrics {
+		var header bool
+		for i, s := range status {
+			v, ok := pm.Value(s)
+			if !ok {
+				continue
+			}
+			if !header {
+				kind := pm.Type
+				if kind == "" {
+					kind = "gauge"
+				}
+				w.header(pm.Name, kind, pm.Help)
+				header = true
+			}
+			w.sample(pm.Name, v, "process", mons[i].Name)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "42"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	status := "Name:\tautobrm\nState:\tS (sleeping)\nPid:\t42\nVmSize:\t  2048 kB\nVmRSS:\t  1024 kB\n"
+	if err := os.WriteFile(filepath.Join(dir, "42", "status"), []byte(status), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"stat": "42 (autobrm) S 1 42 42 0 -1 4194560 100 0 0 0 150 50 0 0 20 0 3 0 180000 2097152 256\n",
+		"io":   "rchar: 10\nwchar: 20\nread_bytes: 4096\nwrite_bytes: 8192\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, "42", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "autobrm.pid"), []byte("42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var conf Config
+	conf.Mon = Monitor{Pid: filepath.Join(dir, "autobrm.pid"), Proc: dir}
+	conf.Procs = []Monitor{{Name: "archiver", Command: "hrd-archiver"}}
+
+	handler, err := setupRoutes(newMemStore(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveRequest(handler, http.MethodGet, "/requests/", "")
+	serveRequest(handler, http.MethodGet, "/requests/", "")
+	serveRequest(handler, http.MethodGet, "/requests/99", "")
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "text/plain")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	body := rec.Body.String()
+	want := []string{
+		`otto_http_requests_total{route="/requests/",method="GET",code="200"} 2`,
+		`otto_http_requests_total{route="/requests/{id}",method="GET",code="404"} 1`,
+		`otto_http_request_duration_seconds_count{route="/requests/",method="GET"} 2`,
+		`otto_http_request_duration_seconds_bucket{route="/requests/",method="GET",le="+Inf"} 2`,
+		`otto_replays{status="pending"} 1`,
+		`otto_hrd_gaps{channel="vic1"} 1`,
+		`otto_vmu_gaps{source="1"} 1`,
+		`otto_process_up{process="autobrm"} 1`,
+		`otto_process_resident_memory_bytes{process="autobrm"} 1048576`,
+		`otto_process_virtual_memory_bytes{process="autobrm"} 2097152`,
+		`otto_process_up{process="archiver"} 0`,
+		"# TYPE otto_process_read_bytes_total counter",
+		`otto_process_read_bytes_total{process="autobrm"} 4096`,
+		`otto_process_written_bytes_total{process="autobrm"} 8192`,
+		"# TYPE otto_replay_pending_seconds gauge",
+	}
+	for _, w := range want {
+		if !strings.Contains(body, w) {
+			t.Errorf("metric not found: %s", w)
+		}
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if str := escapeLabel("a\"b\\c\nd"); str != `a\"b\\c\nd` {
+		t.Errorf("unexpected escaped label: %s", str)
+	}
+}
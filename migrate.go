@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrations embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// loadMigrations reads the migrations of the given driver. Their files are
+// named <version>_<name>.<up|down>.sql.
+func loadMigrations(driver string) ([]Migration, error) {
+	if driver == "" {
+		driver = DriverMySQL
+	}
+	dir := path.Join("migrations", driver)
+	es, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: no migrations found", driver)
+	}
+	set := make(map[int]*Migration)
+	for _, e := range es {
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		ext := path.Ext(name)
+		name = strings.TrimSuffix(name, ext)
+
+		parts := strings.SplitN(name, "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s: invalid migration name", e.Name())
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid migration version", e.Name())
+		}
+		buf, err := fs.ReadFile(migrations, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := set[version]
+		if !ok {
+			m = &Migration{
+				Version: version,
+				Name:    parts[1],
+			}
+			set[version] = m
+		}
+		switch ext {
+		case ".up":
+			m.Up = string(buf)
+		case ".down":
+			m.Down = string(buf)
+		default:
+			return nil, fmt.Errorf("%s: invalid migration direction", e.Name())
+		}
+	}
+	ms := make([]Migration, 0, len(set))
+	for _, m := range set {
+		ms = append(ms, *m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Version < ms[j].Version
+	})
+	return ms, nil
+}
+
+// schemaVersion gives the latest version of the schema known by otto.
+func schemaVersion(driver string) (int, error) {
+	ms, err := loadMigrations(driver)
+	if err != nil || len(ms) == 0 {
+		return 0, err
+	}
+	return ms[len(ms)-1].Version, nil
+}
+
+func currentVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, "select coalesce(max(version), 0) from schema_version").Scan(&version)
+	return version, err
+}
+
+func checkSchema(ctx context.Context, db *sql.DB, driver string) error {
+	want, err := schemaVersion(driver)
+	if err != nil {
+		return err
+	}
+	got, err := currentVersion(ctx, db)
+	if err != nil {
+		return fmt.Errorf("fail to read schema version: %w", err)
+	}
+	if got < want {
+		return fmt.Errorf("schema version %d older than expected version %d (run otto migrate up)", got, want)
+	}
+	return nil
+}
+
+func migrateUp(db *sql.DB, driver string) error {
+	if err := createVersionTable(db); err != nil {
+		return err
+	}
+	ms, err := loadMigrations(driver)
+	if err != nil {
+		return err
+	}
+	curr, err := currentVersion(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	for _, m := range ms {
+		if m.Version <= curr {
+			continue
+		}
+		err := applyMigration(db, m.Up, func(tx *sql.Tx) error {
+			_, err := tx.Exec("insert into schema_version(version, name, timestamp) values(?, ?, ?)", m.Version, m.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+func migrateDown(db *sql.DB, driver string) error {
+	if err := createVersionTable(db); err != nil {
+		return err
+	}
+	ms, err := loadMigrations(driver)
+	if err != nil {
+		return err
+	}
+	curr, err := currentVersion(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	if curr == 0 {
+		return errors.New("no migration to revert")
+	}
+	for i := len(ms) - 1; i >= 0; i-- {
+		m := ms[i]
+		if m.Version != curr {
+			continue
+		}
+		err := applyMigration(db, m.Down, func(tx *sql.Tx) error {
+			_, err := tx.Exec("delete from schema_version where version=?", m.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("migration %04d not found", curr)
+}
+
+func migrateStatus(db *sql.DB, driver string) ([]string, error) {
+	if err := createVersionTable(db); err != nil {
+		return nil, err
+	}
+	ms, err := loadMigrations(driver)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time)
+	rows, err := db.Query("select version, timestamp from schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			version int
+			when    time.Time
+		)
+		if err := rows.Scan(&version, scanTime{&when}); err != nil {
+			return nil, err
+		}
+		applied[version] = when
+	}
+	var lines []string
+	for _, m := range ms {
+		status := "pending"
+		if w, ok := applied[m.Version]; ok {
+			status = "applied " + w.Format(time.RFC3339)
+		}
+		lines = append(lines, fmt.Sprintf("%04d %-24s %s", m.Version, m.Name, status))
+	}
+	return lines, rows.Err()
+}
+
+func createVersionTable(db *sql.DB) error {
+	q := `create table if not exists schema_version(
+		version int not null primary key,
+		name varchar(64) not null,
+		timestamp datetime not null
+	)`
+	_, err := db.Exec(q)
+	return err
+}
+
+// applyMigration runs the statements of script and done in a transaction.
+// With MySQL, DDL statements (create, alter, drop) commit the transaction
+// implicitly: a migration failing halfway keeps the statements already run
+// while its version is not recorded. These statements have to be reverted by
+// hand before the migration is applied again. SQLite runs the whole migration
+// in the transaction.
+func applyMigration(db *sql.DB, script string, done func(*sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, q := range splitStatements(script) {
+		if _, err := tx.Exec(q); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := done(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// statementMarker starts each statement of a migration script. Statements can
+// then contain semicolons (eg: triggers). A script without marker is a single
+// statement.
+const statementMarker = "-- +statement"
+
+// splitStatements splits a script into its statements, each one starting with
+// a line holding only statementMarker. The semicolon ending a statement is
+// removed.
+func splitStatements(script string) []string {
+	var (
+		qs   []string
+		curr strings.Builder
+	)
+	flush := func() {
+		q := strings.TrimSpace(curr.String())
+		if q = strings.TrimSpace(strings.TrimSuffix(q, ";")); q != "" {
+			qs = append(qs, q)
+		}
+		curr.Reset()
+	}
+	for _, line := range strings.Split(script, "\n") {
+		if strings.TrimSpace(line) == statementMarker {
+			flush()
+			continue
+		}
+		curr.WriteString(line)
+		curr.WriteString("\n")
+	}
+	flush()
+	return qs
+}
+
+func runMigrate(action, file string) error {
+	conf, err := loadConfig(file)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(conf.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch action {
+	case "up":
+		return migrateUp(db, conf.DB.Driver)
+	case "down":
+		return migrateDown(db, conf.DB.Driver)
+	case "status":
+		lines, err := migrateStatus(db, conf.DB.Driver)
+		for _, l := range lines {
+			fmt.Println(l)
+		}
+		return err
+	default:
+		return fmt.Errorf("%s: unknown migrate action (expected up, down or status)", action)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	var versions []int
+	for _, driver := range []string{DriverMySQL, DriverSQLite} {
+		ms, err := loadMigrations(driver)
+		if err != nil {
+			t.Fatalf("%s: fail to load migrations: %s", driver, err)
+		}
+		if len(ms) == 0 {
+			t.Fatalf("%s: no migrations found", driver)
+		}
+		for i, m := range ms {
+			if m.Up == "" || m.Down == "" {
+				t.Errorf("%s: %04d_%s: missing up or down script", driver, m.Version, m.Name)
+			}
+			if versions == nil {
+				continue
+			}
+			if i >= len(versions) || versions[i] != m.Version {
+				t.Errorf("%s: %04d_%s: version not defined for all drivers", driver, m.Version, m.Name)
+			}
+		}
+		if versions == nil {
+			for _, m := range ms {
+				versions = append(versions, m.Version)
+			}
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- +statement
+create table foo(
+	id int not null
+);
+
+-- +statement
+-- a comment
+create trigger bar after insert on foo
+begin
+	delete from foo where id < 0;
+end;
+-- +statement
+drop trigger bar`
+
+	qs := splitStatements(script)
+	if len(qs) != 3 {
+		t.Fatalf("unexpected number of statements: want 3, got %d", len(qs))
+	}
+	if !strings.HasSuffix(qs[1], "end") {
+		t.Errorf("statement split before its end: %q", qs[1])
+	}
+	if qs[2] != "drop trigger bar" {
+		t.Errorf("unexpected statement: %q", qs[2])
+	}
+	if qs := splitStatements("drop view foo;\n"); len(qs) != 1 || qs[0] != "drop view foo" {
+		t.Errorf("unexpected statements without marker: %q", qs)
+	}
+}
@@ -0,0 +1,102 @@
+-- +statement
+create table if not exists variable(
+	id int not null auto_increment,
+	name varchar(64) not null,
+	value varchar(1024) not null,
+	primary key(id),
+	unique(name)
+) engine=innodb;
+
+-- +statement
+create table if not exists replay_status(
+	id int not null auto_increment,
+	name varchar(32) not null,
+	workflow int not null,
+	primary key(id),
+	unique(name),
+	unique(workflow)
+) engine=innodb;
+
+-- +statement
+create table if not exists replay(
+	id int not null auto_increment,
+	timestamp datetime not null,
+	startdate datetime(3) not null,
+	enddate datetime(3) not null,
+	priority int,
+	primary key(id),
+	index(timestamp)
+) engine=innodb;
+
+-- +statement
+create table if not exists replay_job(
+	id int not null auto_increment,
+	timestamp datetime not null,
+	replay_id int not null,
+	replay_status_id int not null,
+	text text,
+	primary key(id),
+	index(timestamp),
+	foreign key(replay_id) references replay(id),
+	foreign key(replay_status_id) references replay_status(id)
+) engine=innodb;
+
+-- +statement
+create table if not exists hrd_packet_gap(
+	id int not null auto_increment,
+	timestamp datetime not null,
+	chanel varchar(16) not null,
+	last_sequence_count int unsigned not null,
+	last_timestamp datetime(3) not null,
+	next_sequence_count int unsigned not null,
+	next_timestamp datetime(3) not null,
+	primary key(id),
+	index(timestamp)
+) engine=innodb;
+
+-- +statement
+create table if not exists vmu_record(
+	id int not null auto_increment,
+	source int,
+	phase varchar(64),
+	primary key(id)
+) engine=innodb;
+
+-- +statement
+create table if not exists vmu_packet_gap(
+	id int not null auto_increment,
+	timestamp datetime not null,
+	vmu_record_id int not null,
+	hrd_packet_gap_id int,
+	last_sequence_count int unsigned not null,
+	last_timestamp datetime(3) not null,
+	next_sequence_count int unsigned not null,
+	next_timestamp datetime(3) not null,
+	primary key(id),
+	index(timestamp),
+	foreign key(vmu_record_id) references vmu_record(id),
+	foreign key(hrd_packet_gap_id) references hrd_packet_gap(id)
+) engine=innodb;
+
+-- +statement
+create table if not exists gap_replay_list(
+	id int not null auto_increment,
+	hrd_packet_gap_id int,
+	replay_id int,
+	primary key(id),
+	foreign key(hrd_packet_gap_id) references hrd_packet_gap(id),
+	foreign key(replay_id) references replay(id)
+) engine=innodb;
+
+-- +statement
+insert ignore into replay_status(name, workflow) values
+	('pending', 1),
+	('running', 2),
+	('completed', 3),
+	('corrupted', 4),
+	('failed', 5),
+	('cancelled', 6);
+
+-- +statement
+insert ignore into variable(name, value) values
+	('api_days_back', '15');
@@ -0,0 +1,54 @@
+-- +statement
+drop view if exists pending_duration;
+-- +statement
+drop view if exists max_latest_status;
+-- +statement
+drop view if exists vmu_gap_list;
+-- +statement
+drop view if exists replay_list;
+-- +statement
+drop view if exists automatic_replay_list;
+-- +statement
+drop view if exists replay_job_history;
+-- +statement
+drop view if exists replay_job_list;
+-- +statement
+drop view if exists missing_hrd_list;
+-- +statement
+drop view if exists corrupted_hrd_list;
+-- +statement
+drop view if exists record_infos;
+-- +statement
+drop view if exists source_infos;
+-- +statement
+drop view if exists records_count;
+-- +statement
+drop view if exists jobs_status;
+-- +statement
+drop view if exists items_count;
+-- +statement
+drop view if exists hrd_status_list;
+-- +statement
+drop view if exists hrd_gap_list;
+-- +statement
+drop view if exists channel_infos;
+-- +statement
+drop view if exists completed_replays;
+-- +statement
+drop view if exists recent_status;
+-- +statement
+drop view if exists latest_status;
+-- +statement
+drop view if exists running_workflows;
+-- +statement
+drop view if exists exited_workflows;
+-- +statement
+drop view if exists cancelled_workflow;
+-- +statement
+drop view if exists pending_workflow;
+-- +statement
+drop view if exists completed_workflows;
+-- +statement
+drop view if exists days_back;
+-- +statement
+drop view if exists apidaysback;
@@ -1,21 +1,28 @@
+-- +statement
 create or replace view apidaysback(day) as
 	select ifnull((select value from variable where name='api_days_back' limit 1), 15);
 
+-- +statement
 create or replace view days_back(date) as
 	select date_sub(current_date(), interval (select day from apidaysback) DAY);
 
+-- +statement
 create or replace view completed_workflows(wf) as
 	select workflow from replay_status order by workflow desc limit 4;
 
+-- +statement
 create or replace view pending_workflow(wf) as
 	select min(workflow) from replay_status;
 
+-- +statement
 create or replace view cancelled_workflow(wf) as
 	select max(workflow) from replay_status;
 
+-- +statement
 create or replace view exited_workflows(wf) as
 	select workflow from replay_status order by workflow desc limit 4 offset 1;
 
+-- +statement
 create or replace view running_workflows(wf) as
 	select
 		workflow
@@ -23,6 +30,7 @@ create or replace view running_workflows(wf) as
 	where workflow <> (select wf from pending_workflow)
 		or workflow not in (select wf from completed_workflows);
 
+-- +statement
 create or replace view latest_status(replay, date, status) as
   select
     replay_id,
@@ -33,6 +41,7 @@ create or replace view latest_status(replay, date, status) as
   group by date, replay_id
   order by replay_id;
 
+-- +statement
 create or replace view recent_status(replay, date, status) as
 	select
 		replay_id,
@@ -43,6 +52,7 @@ create or replace view recent_status(replay, date, status) as
 	group by replay_id
 	order by replay_id;
 
+-- +statement
 create or replace view completed_replays(id) as
 	select
 		replay_id
@@ -54,6 +64,7 @@ create or replace view completed_replays(id) as
 		where workflow in (select wf from completed_workflows)
 	);
 
+-- +statement
 create or replace view channel_infos(channel, total) as
   select
     chanel,
@@ -62,6 +73,7 @@ create or replace view channel_infos(channel, total) as
   where timestamp >= (select date from days_back)
   group by chanel;
 
+-- +statement
 create or replace view hrd_gap_list(id, timestamp, channel, last_sequence_count, last_timestamp, next_sequence_count, next_timestamp, corrupted, completed, replay) as
 select
   h.id,
@@ -79,6 +91,7 @@ from hrd_packet_gap h
   left outer join completed_replays r on r.id=i.replay_id
   where h.timestamp >= (select date from days_back);
 
+-- +statement
 create or replace view hrd_status_list(label, timestamp, channel, count) as
   select
     'CORRUPTED',
@@ -98,6 +111,7 @@ create or replace view hrd_status_list(label, timestamp, channel, count) as
   where not corrupted
   group by date, channel;
 
+-- +statement
 create or replace view items_count(label, origin, date, count, missing, duration) as
   select
     'REPLAY' as label,
@@ -136,6 +150,7 @@ create or replace view items_count(label, origin, date, count, missing, duration
     and g.timestamp >= (select date from days_back)
   group by date, r.source;
 
+-- +statement
 create or replace view jobs_status (label, timestamp, count) as
 select
 	'PENDING' as label,
@@ -169,6 +184,7 @@ from latest_status
 where status in (select wf from running_workflows)
 group by date;
 
+-- +statement
 create or replace view records_count(id, total) as
 	select
 		vmu_record_id,
@@ -177,6 +193,7 @@ create or replace view records_count(id, total) as
 	where timestamp >= (select date from days_back)
 	group by vmu_record_id;
 
+-- +statement
 create or replace view source_infos(source, total) as
   select
     r.source,
@@ -186,6 +203,7 @@ create or replace view source_infos(source, total) as
   where r.source is not null
   group by r.source;
 
+-- +statement
 create or replace view record_infos(phase, total) as
   select
     r.phase,
@@ -195,6 +213,7 @@ create or replace view record_infos(phase, total) as
   where r.phase is not null
   group by r.phase;
 
+-- +statement
 create or replace view corrupted_hrd_list(id, total) as
 	select
 		replay,
@@ -203,6 +222,7 @@ create or replace view corrupted_hrd_list(id, total) as
 	where corrupted and timestamp >= (select date from days_back)
 	group by replay;
 
+-- +statement
 create or replace view missing_hrd_list(id, total) as
 	select
 		replay,
@@ -211,6 +231,7 @@ create or replace view missing_hrd_list(id, total) as
 	where timestamp >= (select date from days_back)
 	group by replay;
 
+-- +statement
 create or replace view replay_job_list(replay, text, status, timestamp) as
 	select
 		j.replay_id,
@@ -221,6 +242,18 @@ create or replace view replay_job_list(replay, text, status, timestamp) as
 	join recent_status s on j.replay_id=s.replay and j.replay_status_id=s.status
 	where j.timestamp >= (select date from days_back);
 
+-- +statement
+create or replace view replay_job_history(replay, timestamp, status, workflow, text) as
+	select
+		j.replay_id,
+		j.timestamp,
+		s.name,
+		s.workflow,
+		coalesce(j.text, '')
+	from replay_job j
+		inner join replay_status s on s.id=j.replay_status_id;
+
+-- +statement
 create or replace view automatic_replay_list(replay, total) as
 	select
 		replay,
@@ -229,6 +262,7 @@ create or replace view automatic_replay_list(replay, total) as
 	where timestamp >= (select date from days_back)
 	group by replay;
 
+-- +statement
 create or replace view replay_list(id, timestamp, startdate, enddate, priority, comment, status, automatic, cancellable, corrupted, missing) as
 	select
 		r.id,
@@ -251,6 +285,7 @@ create or replace view replay_list(id, timestamp, startdate, enddate, priority,
 		-- left outer join missing_hrd_list as m on m.id=r.id
 		where r.timestamp >= (select date from days_back);
 
+-- +statement
 create or replace view vmu_gap_list(id, timestamp, last_sequence_count, last_timestamp, next_sequence_count, next_timestamp, source, phase, corrupted, replay, completed) as
 select
 	g.id,
@@ -271,6 +306,7 @@ from vmu_packet_gap g
 	where g.timestamp >= (select date from days_back);
 
 
+-- +statement
 create or replace view max_latest_status(replay,date,status) as
 	select
 		replay,
@@ -279,6 +315,7 @@ create or replace view max_latest_status(replay,date,status) as
 	from latest_status
 	group by replay;
 
+-- +statement
 create or replace view pending_duration(duration) as
 	select
 		coalesce(sum(unix_timestamp(r.enddate)-unix_timestamp(r.startdate)), 0)
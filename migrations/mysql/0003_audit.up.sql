@@ -0,0 +1,13 @@
+-- +statement
+create table if not exists audit(
+	id int not null auto_increment,
+	timestamp datetime not null,
+	actor varchar(64) not null,
+	action varchar(64) not null,
+	target int not null default 0,
+	before_value text,
+	after_value text,
+	origin varchar(255) not null default '',
+	primary key(id),
+	index(timestamp)
+) engine=innodb;
@@ -0,0 +1,14 @@
+-- +statement
+create table if not exists variable_history(
+	id int not null auto_increment,
+	variable_id int not null,
+	timestamp datetime not null,
+	author varchar(64) not null,
+	value varchar(1024) not null,
+	primary key(id),
+	foreign key(variable_id) references variable(id) on delete cascade
+) engine=innodb;
+
+-- +statement
+insert into variable_history(variable_id, timestamp, author, value)
+	select id, now(), 'migration', value from variable;
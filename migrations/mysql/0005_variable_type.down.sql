@@ -0,0 +1,7 @@
+-- +statement
+alter table variable
+	drop column type,
+	drop column min_value,
+	drop column max_value,
+	drop column allowed_values,
+	drop column hazardous;
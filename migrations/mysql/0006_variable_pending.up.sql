@@ -0,0 +1,14 @@
+-- +statement
+create table if not exists variable_pending(
+	id int not null auto_increment,
+	variable_id int not null,
+	timestamp datetime not null,
+	expires datetime not null,
+	author varchar(64) not null,
+	value varchar(1024) not null,
+	token varchar(64) not null,
+	status varchar(16) not null default 'pending',
+	resolver varchar(64),
+	primary key(id),
+	foreign key(variable_id) references variable(id) on delete cascade
+) engine=innodb;
@@ -0,0 +1,11 @@
+-- +statement
+create or replace view replay_job_history(id, replay, timestamp, status, workflow, text) as
+	select
+		j.id,
+		j.replay_id,
+		j.timestamp,
+		s.name,
+		s.workflow,
+		coalesce(j.text, '')
+	from replay_job j
+		inner join replay_status s on s.id=j.replay_status_id;
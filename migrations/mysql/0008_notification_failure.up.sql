@@ -0,0 +1,12 @@
+-- +statement
+create table if not exists notification_failure(
+	id int not null auto_increment,
+	timestamp datetime not null,
+	target varchar(255) not null,
+	type varchar(64) not null,
+	attempts int not null default 0,
+	error text,
+	payload text,
+	primary key(id),
+	index(timestamp)
+) engine=innodb;
@@ -0,0 +1,2 @@
+-- +statement
+drop table if exists alert;
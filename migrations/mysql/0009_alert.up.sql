@@ -0,0 +1,14 @@
+-- +statement
+create table if not exists alert(
+	id int not null auto_increment,
+	rule varchar(64) not null,
+	subject varchar(64) not null default '',
+	state varchar(16) not null,
+	value varchar(64) not null default '',
+	text varchar(255) not null default '',
+	timestamp datetime not null,
+	resolved datetime,
+	primary key(id),
+	index(timestamp),
+	index(state)
+) engine=innodb;
@@ -0,0 +1,12 @@
+-- +statement
+create table if not exists process_sample(
+	id int not null auto_increment,
+	timestamp datetime not null,
+	pid varchar(16) not null,
+	state varchar(16) not null,
+	vmrss bigint not null default 0,
+	vmsize bigint not null default 0,
+	cpu double not null default 0,
+	primary key(id),
+	index(timestamp)
+) engine=innodb;
@@ -0,0 +1,2 @@
+-- +statement
+drop view if exists replay_detail;
@@ -0,0 +1,5 @@
+-- +statement
+update audit set target='0' where target not regexp '^[0-9]+$';
+
+-- +statement
+alter table audit modify target int not null default 0;
@@ -0,0 +1,2 @@
+-- +statement
+alter table audit modify target varchar(255) not null default '';
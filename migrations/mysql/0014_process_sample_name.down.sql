@@ -0,0 +1,4 @@
+-- +statement
+alter table process_sample
+	drop index process_sample_process,
+	drop column process;
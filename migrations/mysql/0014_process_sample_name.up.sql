@@ -0,0 +1,4 @@
+-- +statement
+alter table process_sample
+	add column process varchar(64) not null default 'autobrm' after id,
+	add index process_sample_process(process, timestamp);
@@ -0,0 +1,5 @@
+-- +statement
+drop view if exists vmu_gap_replay;
+
+-- +statement
+drop view if exists hrd_gap_replay;
@@ -0,0 +1,31 @@
+-- +statement
+create or replace view hrd_gap_detail(id, timestamp, channel, last_sequence_count, last_timestamp, next_sequence_count, next_timestamp, corrupted, completed, replay) as
+	select
+		h.id,
+		h.timestamp,
+		h.chanel,
+		h.last_sequence_count,
+		h.last_timestamp,
+		h.next_sequence_count,
+		h.next_timestamp,
+		h.next_sequence_count=h.last_sequence_count,
+		exists(select 1 from gap_replay_list i join completed_replays c on c.id=i.replay_id where i.hrd_packet_gap_id=h.id),
+		coalesce((select max(i.replay_id) from gap_replay_list i where i.hrd_packet_gap_id=h.id), 0)
+	from hrd_packet_gap h;
+
+-- +statement
+create or replace view vmu_gap_detail(id, timestamp, last_sequence_count, last_timestamp, next_sequence_count, next_timestamp, source, phase, corrupted, replay, completed) as
+	select
+		g.id,
+		g.timestamp,
+		g.last_sequence_count,
+		g.last_timestamp,
+		g.next_sequence_count,
+		g.next_timestamp,
+		r.source,
+		r.phase,
+		g.next_sequence_count=g.last_sequence_count,
+		coalesce((select max(i.replay_id) from gap_replay_list i where i.hrd_packet_gap_id=g.hrd_packet_gap_id), 0),
+		exists(select 1 from gap_replay_list i join completed_replays c on c.id=i.replay_id where i.hrd_packet_gap_id=g.hrd_packet_gap_id)
+	from vmu_packet_gap g
+		join vmu_record r on g.vmu_record_id=r.id;
@@ -0,0 +1,16 @@
+-- +statement
+drop table if exists gap_replay_list;
+-- +statement
+drop table if exists vmu_packet_gap;
+-- +statement
+drop table if exists vmu_record;
+-- +statement
+drop table if exists hrd_packet_gap;
+-- +statement
+drop table if exists replay_job;
+-- +statement
+drop table if exists replay;
+-- +statement
+drop table if exists replay_status;
+-- +statement
+drop table if exists variable;
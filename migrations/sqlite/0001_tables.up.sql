@@ -0,0 +1,81 @@
+-- +statement
+create table if not exists variable(
+	id integer primary key autoincrement,
+	name varchar(64) not null unique,
+	value varchar(1024) not null
+);
+
+-- +statement
+create table if not exists replay_status(
+	id integer primary key autoincrement,
+	name varchar(32) not null unique,
+	workflow integer not null unique
+);
+
+-- +statement
+create table if not exists replay(
+	id integer primary key autoincrement,
+	timestamp datetime not null,
+	startdate datetime not null,
+	enddate datetime not null,
+	priority integer
+);
+
+-- +statement
+create table if not exists replay_job(
+	id integer primary key autoincrement,
+	timestamp datetime not null,
+	replay_id integer not null references replay(id),
+	replay_status_id integer not null references replay_status(id),
+	text text
+);
+
+-- +statement
+create table if not exists hrd_packet_gap(
+	id integer primary key autoincrement,
+	timestamp datetime not null,
+	chanel varchar(16) not null,
+	last_sequence_count integer not null,
+	last_timestamp datetime not null,
+	next_sequence_count integer not null,
+	next_timestamp datetime not null
+);
+
+-- +statement
+create table if not exists vmu_record(
+	id integer primary key autoincrement,
+	source integer,
+	phase varchar(64)
+);
+
+-- +statement
+create table if not exists vmu_packet_gap(
+	id integer primary key autoincrement,
+	timestamp datetime not null,
+	vmu_record_id integer not null references vmu_record(id),
+	hrd_packet_gap_id integer references hrd_packet_gap(id),
+	last_sequence_count integer not null,
+	last_timestamp datetime not null,
+	next_sequence_count integer not null,
+	next_timestamp datetime not null
+);
+
+-- +statement
+create table if not exists gap_replay_list(
+	id integer primary key autoincrement,
+	hrd_packet_gap_id integer references hrd_packet_gap(id),
+	replay_id integer references replay(id)
+);
+
+-- +statement
+insert or ignore into replay_status(name, workflow) values
+	('pending', 1),
+	('running', 2),
+	('completed', 3),
+	('corrupted', 4),
+	('failed', 5),
+	('cancelled', 6);
+
+-- +statement
+insert or ignore into variable(name, value) values
+	('api_days_back', '15');
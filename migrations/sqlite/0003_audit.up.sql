@@ -0,0 +1,14 @@
+-- +statement
+create table if not exists audit(
+	id integer primary key autoincrement,
+	timestamp datetime not null,
+	actor varchar(64) not null,
+	action varchar(64) not null,
+	target integer not null default 0,
+	before_value text,
+	after_value text,
+	origin varchar(255) not null default ''
+);
+
+-- +statement
+create index if not exists audit_timestamp on audit(timestamp);
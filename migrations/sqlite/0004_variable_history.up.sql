@@ -0,0 +1,12 @@
+-- +statement
+create table if not exists variable_history(
+	id integer primary key autoincrement,
+	variable_id integer not null references variable(id) on delete cascade,
+	timestamp datetime not null,
+	author varchar(64) not null,
+	value varchar(1024) not null
+);
+
+-- +statement
+insert into variable_history(variable_id, timestamp, author, value)
+	select id, datetime('now'), 'migration', value from variable;
@@ -0,0 +1,10 @@
+-- +statement
+alter table variable drop column hazardous;
+-- +statement
+alter table variable drop column allowed_values;
+-- +statement
+alter table variable drop column max_value;
+-- +statement
+alter table variable drop column min_value;
+-- +statement
+alter table variable drop column type;
@@ -0,0 +1,13 @@
+-- +statement
+alter table variable add column type varchar(16) not null default 'string';
+-- +statement
+alter table variable add column min_value varchar(64);
+-- +statement
+alter table variable add column max_value varchar(64);
+-- +statement
+alter table variable add column allowed_values varchar(1024);
+-- +statement
+alter table variable add column hazardous boolean not null default false;
+
+-- +statement
+update variable set type='int', min_value='1', max_value='365' where name='api_days_back';
@@ -0,0 +1,12 @@
+-- +statement
+create table if not exists variable_pending(
+	id integer primary key autoincrement,
+	variable_id integer not null references variable(id) on delete cascade,
+	timestamp datetime not null,
+	expires datetime not null,
+	author varchar(64) not null,
+	value varchar(1024) not null,
+	token varchar(64) not null,
+	status varchar(16) not null default 'pending',
+	resolver varchar(64)
+);
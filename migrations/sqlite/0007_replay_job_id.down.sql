@@ -0,0 +1,12 @@
+-- +statement
+drop view if exists replay_job_history;
+-- +statement
+create view replay_job_history(replay, timestamp, status, workflow, text) as
+	select
+		j.replay_id,
+		j.timestamp,
+		s.name,
+		s.workflow,
+		coalesce(j.text, '')
+	from replay_job j
+		inner join replay_status s on s.id=j.replay_status_id;
@@ -0,0 +1,13 @@
+-- +statement
+create table if not exists notification_failure(
+	id integer primary key autoincrement,
+	timestamp datetime not null,
+	target varchar(255) not null,
+	type varchar(64) not null,
+	attempts integer not null default 0,
+	error text,
+	payload text
+);
+
+-- +statement
+create index if not exists notification_failure_timestamp on notification_failure(timestamp);
@@ -0,0 +1,16 @@
+-- +statement
+create table if not exists alert(
+	id integer primary key autoincrement,
+	rule varchar(64) not null,
+	subject varchar(64) not null default '',
+	state varchar(16) not null,
+	value varchar(64) not null default '',
+	text varchar(255) not null default '',
+	timestamp datetime not null,
+	resolved datetime
+);
+
+-- +statement
+create index if not exists alert_timestamp on alert(timestamp);
+-- +statement
+create index if not exists alert_state on alert(state);
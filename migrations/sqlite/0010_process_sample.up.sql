@@ -0,0 +1,13 @@
+-- +statement
+create table if not exists process_sample(
+	id integer primary key autoincrement,
+	timestamp datetime not null,
+	pid varchar(16) not null,
+	state varchar(16) not null,
+	vmrss integer not null default 0,
+	vmsize integer not null default 0,
+	cpu real not null default 0
+);
+
+-- +statement
+create index if not exists process_sample_timestamp on process_sample(timestamp);
@@ -0,0 +1,19 @@
+-- +statement
+drop view if exists replay_detail;
+-- +statement
+create view replay_detail(id, timestamp, startdate, enddate, priority, comment, status, automatic, cancellable, corrupted, missing) as
+	select
+		r.id,
+		j.timestamp,
+		r.startdate,
+		r.enddate,
+		coalesce(r.priority, -1) as priority,
+		coalesce(j.text, '') as comment,
+		s.name,
+		exists(select 1 from gap_replay_list g where g.replay_id=r.id) as automatic,
+		s.workflow not in (select wf from completed_workflows) as cancellable,
+		0 as corrupted,
+		0 as missing
+	from replay as r
+		inner join replay_job as j on j.id=(select max(x.id) from replay_job x where x.replay_id=r.id)
+		inner join replay_status as s on s.id=j.replay_status_id;
@@ -0,0 +1,24 @@
+-- +statement
+create table if not exists audit_target(
+	id integer primary key autoincrement,
+	timestamp datetime not null,
+	actor varchar(64) not null,
+	action varchar(64) not null,
+	target varchar(255) not null default '',
+	before_value text,
+	after_value text,
+	origin varchar(255) not null default ''
+);
+
+-- +statement
+insert into audit_target(id, timestamp, actor, action, target, before_value, after_value, origin)
+	select id, timestamp, actor, action, cast(target as text), before_value, after_value, origin from audit;
+
+-- +statement
+drop table audit;
+
+-- +statement
+alter table audit_target rename to audit;
+
+-- +statement
+create index if not exists audit_timestamp on audit(timestamp);
@@ -0,0 +1,4 @@
+-- +statement
+drop index if exists process_sample_process;
+-- +statement
+alter table process_sample drop column process;
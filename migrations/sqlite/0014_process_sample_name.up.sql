@@ -0,0 +1,4 @@
+-- +statement
+alter table process_sample add column process varchar(64) not null default 'autobrm';
+-- +statement
+create index if not exists process_sample_process on process_sample(process, timestamp);
@@ -0,0 +1,39 @@
+-- +statement
+drop view if exists hrd_gap_replay;
+
+-- +statement
+create view hrd_gap_replay(id, timestamp, channel, last_sequence_count, last_timestamp, next_sequence_count, next_timestamp, corrupted, completed, replay) as
+	select
+		h.id,
+		h.timestamp,
+		h.chanel,
+		h.last_sequence_count,
+		h.last_timestamp,
+		h.next_sequence_count,
+		h.next_timestamp,
+		h.next_sequence_count=h.last_sequence_count,
+		exists(select 1 from completed_replays c where c.id=i.replay_id),
+		i.replay_id
+	from gap_replay_list i
+		join hrd_packet_gap h on h.id=i.hrd_packet_gap_id;
+
+-- +statement
+drop view if exists vmu_gap_replay;
+
+-- +statement
+create view vmu_gap_replay(id, timestamp, last_sequence_count, last_timestamp, next_sequence_count, next_timestamp, source, phase, corrupted, replay, completed) as
+	select
+		g.id,
+		g.timestamp,
+		g.last_sequence_count,
+		g.last_timestamp,
+		g.next_sequence_count,
+		g.next_timestamp,
+		r.source,
+		r.phase,
+		g.next_sequence_count=g.last_sequence_count,
+		i.replay_id,
+		exists(select 1 from completed_replays c where c.id=i.replay_id)
+	from gap_replay_list i
+		join vmu_packet_gap g on g.hrd_packet_gap_id=i.hrd_packet_gap_id
+		join vmu_record r on g.vmu_record_id=r.id;
@@ -0,0 +1,73 @@
+-- +statement
+drop view if exists hrd_status_list;
+-- +statement
+create view hrd_status_list(label, timestamp, channel, count) as
+  select
+    'CORRUPTED',
+    date(timestamp) as date,
+    channel,
+    count(id)
+  from hrd_gap_list
+  where corrupted
+  group by date, channel
+  union all
+  select
+    'MISSING',
+    date(timestamp) as date,
+    channel,
+    sum(next_sequence_count-last_sequence_count)
+  from hrd_gap_list
+  where not corrupted
+  group by date, channel;
+
+-- +statement
+drop view if exists items_count;
+-- +statement
+create view items_count(label, origin, date, count, missing, duration) as
+  select
+    'REPLAY' as label,
+    'ALL' as origin,
+    date(timestamp) as date,
+    count(id) as total,
+    0,
+    sum(strftime('%s', enddate) - strftime('%s', startdate)) as duration
+  from replay
+  where enddate > startdate
+    and replay.timestamp >= (select date from days_back)
+  group by date
+  union all
+  select
+    'HRD' as label,
+    chanel as origin,
+    date(timestamp) as date,
+    count(id) as total,
+    sum(next_sequence_count-last_sequence_count),
+    sum(strftime('%s', next_timestamp) - strftime('%s', last_timestamp)) as duration
+  from hrd_packet_gap
+  where next_timestamp > last_timestamp
+    and hrd_packet_gap.timestamp >= (select date from days_back)
+  group by date, chanel
+  union all
+  select
+    'VMU' as label,
+    r.source as origin,
+    date(g.timestamp) as date,
+    count(g.id) as total,
+    sum(next_sequence_count-last_sequence_count),
+    sum(strftime('%s', next_timestamp) - strftime('%s', last_timestamp)) as duration
+  from vmu_packet_gap as g
+    inner join vmu_record as r on g.vmu_record_id=r.id
+  where next_timestamp > last_timestamp
+    and g.timestamp >= (select date from days_back)
+  group by date, r.source;
+
+-- +statement
+drop view if exists missing_hrd_list;
+-- +statement
+create view missing_hrd_list(id, total) as
+	select
+		replay,
+		sum(next_sequence_count-last_sequence_count)
+	from hrd_gap_list
+	where timestamp >= (select date from days_back)
+	group by replay;
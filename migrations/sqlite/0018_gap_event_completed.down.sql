@@ -0,0 +1,35 @@
+-- +statement
+drop view if exists hrd_gap_event;
+
+-- +statement
+create view hrd_gap_event(id, timestamp, channel, last_sequence_count, last_timestamp, next_sequence_count, next_timestamp, replay, completed) as
+	select
+		h.id,
+		h.timestamp,
+		h.chanel,
+		h.last_sequence_count,
+		h.last_timestamp,
+		h.next_sequence_count,
+		h.next_timestamp,
+		coalesce((select max(i.replay_id) from gap_replay_list i where i.hrd_packet_gap_id=h.id), 0),
+		0
+	from hrd_packet_gap h;
+
+-- +statement
+drop view if exists vmu_gap_event;
+
+-- +statement
+create view vmu_gap_event(id, timestamp, last_sequence_count, last_timestamp, next_sequence_count, next_timestamp, source, phase, replay, completed) as
+	select
+		g.id,
+		g.timestamp,
+		g.last_sequence_count,
+		g.last_timestamp,
+		g.next_sequence_count,
+		g.next_timestamp,
+		r.source,
+		r.phase,
+		coalesce((select max(i.replay_id) from gap_replay_list i where i.hrd_packet_gap_id=g.hrd_packet_gap_id), 0),
+		0
+	from vmu_packet_gap g
+		join vmu_record r on g.vmu_record_id=r.id;
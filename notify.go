@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	NotifyReplayCompleted = "replay.completed"
+	NotifyReplayCorrupted = "replay.corrupted"
+	NotifyReplayFailed    = "replay.failed"
+	NotifyReplayCancelled = "replay.cancelled"
+	NotifyReplayPending   = "replay.pending"
+	NotifyGapBurst        = "gap.burst"
+)
+
+// names of the replay status as registered in the replay_status table.
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusCorrupted = "corrupted"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+const (
+	DefaultNotifyInterval = time.Second * 30
+	DefaultNotifyRetries  = 3
+	DefaultNotifyBackoff  = time.Second * 5
+	DefaultNotifyTimeout  = time.Second * 10
+	DefaultNotifyWorkers  = 4
+	DefaultNotifyQueue    = 64
+)
+
+type WebhookConfig struct {
+	URL    string
+	Events []string
+	Secret string
+}
+
+// NotifyConfig configures the notifications sent by otto. Durations are
+// given in seconds. Pending and Burst disable their notifications when not
+// set.
+type NotifyConfig struct {
+	Interval int
+	Pending  int
+	Burst    int
+	Window   int
+	Retries  int
+	Backoff  int
+	Timeout  int
+	Hooks    []WebhookConfig `toml:"webhook"`
+}
+
+func (c NotifyConfig) interval() time.Duration {
+	return seconds(c.Interval, DefaultNotifyInterval)
+}
+
+// Sinks gives the targets of the notifications configured by c.
+func (c NotifyConfig) Sinks() []Sink {
+	var (
+		ss      []Sink
+		retries = c.Retries
+		client  = &http.Client{Timeout: seconds(c.Timeout, DefaultNotifyTimeout)}
+	)
+	if retries <= 0 {
+		retries = DefaultNotifyRetries
+	}
+	for _, h := range c.Hooks {
+		w := webhook{
+			url:     h.URL,
+			secret:  h.Secret,
+			events:  h.Events,
+			client:  client,
+			retries: retries,
+			backoff: seconds(c.Backoff, DefaultNotifyBackoff),
+		}
+		ss = append(ss, w)
+	}
+	return ss
+}
+
+func seconds(n int, def time.Duration) time.Duration {
+	if n <= 0 {
+		return def
+	}
+	return time.Duration(n) * time.Second
+}
+
+type Notification struct {
+	Type string      `json:"type"`
+	When time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// Sink is a target of notifications.
+type Sink interface {
+	Accept(string) bool
+	Notify(context.Context, Notification) error
+}
+
+type NotifyFailure struct {
+	Id       int             `json:"id"`
+	When     time.Time       `json:"time"`
+	Target   string          `json:"target"`
+	Type     string          `json:"type"`
+	Attempts int             `json:"attempts"`
+	Err      string          `json:"error"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+type NotifyStore interface {
+	RegisterNotifyFailure(context.Context, NotifyFailure) error
+	FetchNotifyFailures(context.Context, Criteria) (int, []NotifyFailure, error)
+}
+
+// DeliveryError is returned by a Sink when a notification can not be
+// delivered to its target.
+type DeliveryError struct {
+	Target   string
+	Attempts int
+	Err      error
+}
+
+func (e DeliveryError) Error() string {
+	return fmt.Sprintf("%s: delivery failed after %d attempt(s): %s", e.Target, e.Attempts, e.Err)
+}
+
+func (e DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", int(e))
+}
+
+// temporary reports whether the request can be sent again.
+func (e statusError) temporary() bool {
+	return e >= 500 || e == http.StatusTooManyRequests || e == http.StatusRequestTimeout
+}
+
+// webhook posts notifications as JSON to an URL. The body is signed with
+// HMAC-SHA256 when a secret is set and the signature is given in the
+// X-Otto-Signature header.
+type webhook struct {
+	url     string
+	secret  string
+	events  []string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+func (w webhook) Accept(kind string) bool {
+	if len(w.events) == 0 {
+		return true
+	}
+	for _, e := range w.events {
+		if e == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (w webhook) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	wait := w.backoff
+	for i := 0; ; i++ {
+		err = w.post(ctx, n.Type, body)
+		if err == nil {
+			return nil
+		}
+		var code statusError
+		if i >= w.retries || (errors.As(err, &code) && !code.temporary()) {
+			return DeliveryError{Target: w.url, Attempts: i + 1, Err: err}
+		}
+		select {
+		case <-ctx.Done():
+			return DeliveryError{Target: w.url, Attempts: i + 1, Err: ctx.Err()}
+		case <-time.After(wait):
+			wait *= 2
+		}
+	}
+}
+
+func (w webhook) post(ctx context.Context, kind string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Otto-Event", kind)
+	if w.secret != "" {
+		req.Header.Set("X-Otto-Signature", "sha256="+signPayload(w.secret, body))
+	}
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return statusError(res.StatusCode)
+	}
+	return nil
+}
+
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type GapBurst struct {
+	Channel string `json:"channel"`
+	Count   int    `json:"count"`
+	Window  int    `json:"window"`
+	Gaps    []int  `json:"gaps"`
+}
+
+// Notifier looks periodically for the changes in the database that should be
+// notified and sends them to its sinks.
+type Notifier struct {
+	db      Store
+	sinks   []Sink
+	every   time.Duration
+	timeout time.Duration
+	pending time.Duration
+	burst   int
+	window  time.Duration
+
+	mu       sync.Mutex
+	cursor   *EventCursor
+	notified map[int]struct{}
+	gaps     map[string][]HRDGap
+	fired    map[string]time.Time
+}
+
+func NewNotifier(db Store, conf NotifyConfig, sinks []Sink, timeout time.Duration) *Notifier {
+	n := Notifier{
+		db:       db,
+		sinks:    sinks,
+		every:    conf.interval(),
+		timeout:  timeout,
+		burst:    conf.Burst,
+		notified: make(map[int]struct{}),
+		gaps:     make(map[string][]HRDGap),
+		fired:    make(map[string]time.Time),
+	}
+	if conf.Pending > 0 {
+		n.pending = time.Duration(conf.Pending) * time.Second
+	}
+	if conf.Burst > 0 {
+		n.window = seconds(conf.Window, time.Hour)
+	}
+	return &n
+}
+
+func (n *Notifier) Run(ctx context.Context) {
+	queue := dispatch(ctx, n.db, n.sinks, n.timeout)
+	defer close(queue)
+
+	tick := time.NewTicker(n.every)
+	defer tick.Stop()
+	for {
+		ns, err := n.Check(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fail to check notifications: %s\n", err)
+		}
+		for _, x := range ns {
+			select {
+			case queue <- x:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// Check gives the notifications to send since its last call. The first call
+// only looks for pending replays.
+func (n *Notifier) Check(ctx context.Context) ([]Notification, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	var ns []Notification
+	if n.cursor == nil {
+		c, err := n.db.FetchEventCursor(ctx)
+		if err != nil {
+			return nil, err
+		}
+		n.cursor = &c
+	} else {
+		es, err := n.db.FetchEvents(ctx, *n.cursor)
+		if err != nil && !errors.Is(err, ErrEmpty) {
+			return nil, err
+		}
+		for _, e := range es {
+			if x, ok := n.checkEvent(e); ok {
+				ns = append(ns, x)
+			}
+			*n.cursor = e.Cursor
+		}
+	}
+	if n.pending > 0 {
+		xs, err := n.checkPending(ctx)
+		if err != nil {
+			return ns, err
+		}
+		ns = append(ns, xs...)
+	}
+	return ns, nil
+}
+
+func (n *Notifier) checkEvent(e Event) (Notification, bool) {
+	x := Notification{When: e.When, Data: e.Data}
+	switch d := e.Data.(type) {
+	case ReplayEvent:
+		switch d.Status {
+		case StatusCompleted:
+			x.Type = NotifyReplayCompleted
+		case StatusCorrupted:
+			x.Type = NotifyReplayCorrupted
+		case StatusFailed:
+			x.Type = NotifyReplayFailed
+		case StatusCancelled:
+			x.Type = NotifyReplayCancelled
+		default:
+			return x, false
+		}
+	case HRDGap:
+		if n.burst <= 0 {
+			return x, false
+		}
+		return n.checkBurst(d)
+	default:
+		return x, false
+	}
+	return x, true
+}
+
+func (n *Notifier) checkBurst(g HRDGap) (Notification, bool) {
+	var (
+		x  Notification
+		gs = append(n.gaps[g.Channel], g)
+		i  int
+	)
+	for i < len(gs) && g.When.Sub(gs[i].When) > n.window {
+		i++
+	}
+	gs = gs[i:]
+	n.gaps[g.Channel] = gs
+	if len(gs) <= n.burst {
+		return x, false
+	}
+	if last, ok := n.fired[g.Channel]; ok && g.When.Sub(last) < n.window {
+		return x, false
+	}
+	n.fired[g.Channel] = g.When
+	b := GapBurst{
+		Channel: g.Channel,
+		Count:   len(gs),
+		Window:  int(n.window.Seconds()),
+	}
+	for _, g := range gs {
+		b.Gaps = append(b.Gaps, g.Id)
+	}
+	x.Type = NotifyGapBurst
+	x.When = g.When
+	x.Data = b
+	return x, true
+}
+
+func (n *Notifier) checkPending(ctx context.Context) ([]Notification, error) {
+	_, rs, err := n.db.FetchReplays(ctx, Criteria{Status: StatusPending})
+	if err != nil && !errors.Is(err, ErrEmpty) {
+		return nil, err
+	}
+	var (
+		ns      []Notification
+		now     = time.Now().UTC()
+		pending = make(map[int]struct{})
+	)
+	for _, r := range rs {
+		if now.Sub(r.When) < n.pending {
+			continue
+		}
+		pending[r.Id] = struct{}{}
+		if _, ok := n.notified[r.Id]; ok {
+			continue
+		}
+		ns = append(ns, Notification{Type: NotifyReplayPending, When: now, Data: r})
+	}
+	n.notified = pending
+	return ns, nil
+}
+
+// Send gives x to every sink accepting it and registers the failed
+// deliveries.
+func (n *Notifier) Send(ctx context.Context, x Notification) {
+	deliver(ctx, n.db, n.sinks, n.timeout, x)
+}
+
+// dispatch gives a queue whose notifications are delivered by a fixed number
+// of workers until it is closed. Sending to the queue blocks once it is full
+// so that slow sinks delay the next checks instead of piling up deliveries.
+func dispatch(ctx context.Context, db NotifyStore, sinks []Sink, timeout time.Duration) chan<- Notification {
+	queue := make(chan Notification, DefaultNotifyQueue)
+	for i := 0; i < DefaultNotifyWorkers; i++ {
+		go func() {
+			for x := range queue {
+				deliver(ctx, db, sinks, timeout, x)
+			}
+		}()
+	}
+	return queue
+}
+
+func deliver(ctx context.Context, db NotifyStore, sinks []Sink, timeout time.Duration, x Notification) {
+	for _, s := range sinks {
+		if !s.Accept(x.Type) {
+			continue
+		}
+		err := s.Notify(ctx, x)
+		if err == nil {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "fail to send %s notification: %s\n", x.Type, err)
+
+		f := NotifyFailure{
+			When: time.Now().UTC(),
+			Type: x.Type,
+			Err:  err.Error(),
+		}
+		var de DeliveryError
+		if errors.As(err, &de) {
+			f.Target, f.Attempts, f.Err = de.Target, de.Attempts, de.Err.Error()
+		}
+		f.Payload, _ = json.Marshal(x)
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		if err := db.RegisterNotifyFailure(ctx, f); err != nil {
+			fmt.Fprintf(os.Stderr, "fail to register failed notification: %s\n", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls int
+		codes = []int{http.StatusInternalServerError, http.StatusOK}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if sig := r.Header.Get("X-Otto-Signature"); sig != "sha256="+signPayload("secret", body) {
+			t.Errorf("unexpected signature: %s", sig)
+		}
+		if kind := r.Header.Get("X-Otto-Event"); kind != NotifyReplayCompleted {
+			t.Errorf("unexpected event: %s", kind)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		code := http.StatusBadRequest
+		if calls < len(codes) {
+			code = codes[calls]
+		}
+		calls++
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+
+	w := webhook{
+		url:     srv.URL,
+		secret:  "secret",
+		events:  []string{NotifyReplayCompleted},
+		client:  srv.Client(),
+		retries: 3,
+		backoff: time.Millisecond,
+	}
+	if w.Accept(NotifyGapBurst) {
+		t.Errorf("webhook should not accept %s", NotifyGapBurst)
+	}
+	x := Notification{Type: NotifyReplayCompleted, When: time.Now(), Data: Replay{Id: 1}}
+	if err := w.Notify(context.Background(), x); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if calls != 2 {
+		t.Errorf("unexpected number of calls: want 2, got %d", calls)
+	}
+
+	err := w.Notify(context.Background(), x)
+	var de DeliveryError
+	if !errors.As(err, &de) {
+		t.Fatalf("expected delivery error, got %v", err)
+	}
+	if de.Attempts != 1 {
+		t.Errorf("client error should not be retried: %d attempts", de.Attempts)
+	}
+}
+
+type failingSink struct{}
+
+func (failingSink) Accept(string) bool {
+	return true
+}
+
+func (failingSink) Notify(context.Context, Notification) error {
+	return DeliveryError{Target: "test", Attempts: 2, Err: errors.New("unreachable")}
+}
+
+func TestNotifier(t *testing.T) {
+	var (
+		db   = newMemStore()
+		ctx  = context.Background()
+		conf = NotifyConfig{Pending: 60, Burst: 2, Window: 600}
+		n    = NewNotifier(db, conf, []Sink{failingSink{}}, time.Second)
+	)
+	types := func() []string {
+		t.Helper()
+		ns, err := n.Check(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ts []string
+		for _, x := range ns {
+			ts = append(ts, x.Type)
+		}
+		return ts
+	}
+	if ts := types(); len(ts) != 1 || ts[0] != NotifyReplayPending {
+		t.Fatalf("unexpected notifications: %v", ts)
+	}
+	if ts := types(); len(ts) != 0 {
+		t.Fatalf("pending replay notified twice: %v", ts)
+	}
+
+	if _, err := db.CancelReplay(ctx, 1, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if ts := types(); len(ts) != 1 || ts[0] != NotifyReplayCancelled {
+		t.Fatalf("unexpected notifications after cancel: %v", ts)
+	}
+
+	now := time.Date(2020, 4, 1, 13, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		ends := now.Add(time.Duration(i) * time.Minute)
+		db.seedGapHRD(HRDGap{
+			Gap:     Gap{Period: Period{Starts: ends.Add(-time.Second), Ends: ends}},
+			Channel: "vic2",
+		})
+	}
+	ns, err := n.Check(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 1 || ns[0].Type != NotifyGapBurst {
+		t.Fatalf("unexpected notifications for gaps: %v", ns)
+	}
+	if b := ns[0].Data.(GapBurst); b.Channel != "vic2" || b.Count != 3 {
+		t.Errorf("unexpected burst: %+v", b)
+	}
+
+	n.Send(ctx, ns[0])
+	if len(db.failures) != 1 {
+		t.Fatalf("failed delivery not registered")
+	}
+	if f := db.failures[0]; f.Target != "test" || f.Attempts != 2 || f.Type != NotifyGapBurst {
+		t.Errorf("unexpected failure: %+v", f)
+	}
+}
+
+func TestNotifierReplayStatus(t *testing.T) {
+	n := NewNotifier(newMemStore(), NotifyConfig{}, nil, time.Second)
+	data := []struct {
+		Status string
+		Type   string
+	}{
+		{Status: StatusPending},
+		{Status: "running"},
+		{Status: StatusCompleted, Type: NotifyReplayCompleted},
+		{Status: StatusCorrupted, Type: NotifyReplayCorrupted},
+		{Status: StatusFailed, Type: NotifyReplayFailed},
+		{Status: StatusCancelled, Type: NotifyReplayCancelled},
+	}
+	for _, d := range data {
+		e := Event{
+			Id:   1,
+			Type: EventReplay,
+			Data: ReplayEvent{Replay: 1, Job: Job{Status: d.Status}},
+		}
+		x, ok := n.checkEvent(e)
+		if ok != (d.Type != "") || x.Type != d.Type {
+			t.Errorf("%s: want notification %q, got %q (%t)", d.Status, d.Type, x.Type, ok)
+		}
+	}
+}
@@ -3,18 +3,106 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// ClockTicks is the number of clock ticks per second (USER_HZ) used by the
+// kernel for the times given in /proc/<pid>/stat. USER_HZ is part of the ABI
+// of the kernel and is 100 on all the architectures supported by Linux
+// (except alpha, not supported by otto), whatever the value of HZ, so it is
+// not read from sysconf(_SC_CLK_TCK) which would need cgo.
+const ClockTicks = 100
+
+// cpuWindow is the minimum time between two computations of the CPU usage of
+// a process. Reads within the window give the usage computed last so that
+// the value does not depend on how often the process is read.
+const cpuWindow = 10 * time.Second
+
+const DefaultProcess = "autobrm"
+
+// Monitor reads the status of a process from the proc filesystem. The
+// process is given by its pidfile or, without pidfile, by the first process
+// whose command line matches Command.
 type Monitor struct {
-	Pid  string `toml:"pidfile"`
-	Proc string `toml:"proc"`
+	Name    string          `toml:"name"`
+	Pid     string          `toml:"pidfile"`
+	Proc    string          `toml:"proc"`
+	Command string          `toml:"command"`
+	History HistoryConfig   `toml:"history"`
+	Actions []ControlAction `toml:"action"`
+	Logs    []string        `toml:"logs"`
+	LogTime string          `toml:"logtime"`
+
+	cpu     *cpuSampler
+	command *regexp.Regexp
+}
+
+// withDefaults gives a copy of m reading the proc filesystem from /proc
+// unless another directory is configured. The copy keeps the last CPU times
+// read to compute the CPU usage between two reads and the command compiled
+// as a regular expression, or quoted if it is not a valid one.
+func (m Monitor) withDefaults() Monitor {
+	if m.Proc == "" {
+		m.Proc = "/proc"
+	} else {
+		m.Proc = filepath.Clean(m.Proc)
+	}
+	if m.cpu == nil {
+		m.cpu = new(cpuSampler)
+	}
+	m.command = nil
+	if m.Command != "" {
+		re, err := regexp.Compile(m.Command)
+		if err != nil {
+			re = regexp.MustCompile(regexp.QuoteMeta(m.Command))
+		}
+		m.command = re
+	}
+	return m
+}
+
+// Monitors gives the monitors of autobrm and of the other processes
+// configured. The one of autobrm is always the first. The other processes use
+// the proc filesystem of autobrm unless they have their own.
+func (c Config) Monitors() ([]Monitor, error) {
+	mon := c.Mon
+	if mon.Name == "" {
+		mon.Name = DefaultProcess
+	}
+	var (
+		ms   = []Monitor{mon.withDefaults()}
+		seen = map[string]struct{}{mon.Name: {}}
+	)
+	for _, m := range c.Procs {
+		if m.Name == "" {
+			return nil, fmt.Errorf("process without name")
+		}
+		if _, ok := seen[m.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicate process", m.Name)
+		}
+		if m.Pid == "" && m.Command == "" {
+			return nil, fmt.Errorf("%s: pidfile or command expected", m.Name)
+		}
+		if m.Proc == "" {
+			m.Proc = c.Mon.Proc
+		}
+		seen[m.Name] = struct{}{}
+		ms = append(ms, m.withDefaults())
+	}
+	return ms, nil
 }
 
 func (m Monitor) readProcess() map[string]string {
-	buf, err := ioutil.ReadFile(m.Pid)
+	pid, err := m.readPid()
 	status := map[string]string{
 		"cmdline": "unknown",
 		"name":    "unknown",
@@ -26,14 +114,102 @@ func (m Monitor) readProcess() map[string]string {
 	if err != nil {
 		return status
 	}
-	pid := strings.TrimSpace(string(buf))
-	status = readCommandStatus(filepath.Join(m.Proc, pid))
+	dir := filepath.Join(m.Proc, pid)
+	if i, err := os.Stat(dir); err != nil || !i.IsDir() {
+		status["pid"] = pid
+		status["stale"] = "true"
+		return status
+	}
+	status = readCommandStatus(dir)
+	status["stale"] = strconv.FormatBool(!m.matchCommand(status["cmdline"]))
+
+	st, err := readCommandStat(dir)
+	if err != nil {
+		return status
+	}
+	now := time.Now()
+	status["ppid"] = strconv.Itoa(st.Parent)
+	status["threads"] = strconv.Itoa(st.Threads)
+	status["utime"] = strconv.FormatFloat(float64(st.User)/ClockTicks, 'f', 2, 64)
+	status["stime"] = strconv.FormatFloat(float64(st.System)/ClockTicks, 'f', 2, 64)
+	if boot, err := readBootTime(m.Proc); err == nil {
+		started := boot.Add(time.Duration(st.Start) * time.Second / ClockTicks)
+		status["starttime"] = started.UTC().Format(time.RFC3339)
+		status["uptime"] = strconv.Itoa(int(now.Sub(started).Seconds()))
+		if m.cpu != nil {
+			status["cpu"] = strconv.FormatFloat(m.cpu.usage(pid, st, started, now), 'f', 2, 64)
+		}
+	}
+	if n, err := countFiles(filepath.Join(dir, "fd")); err == nil {
+		status["fds"] = strconv.Itoa(n)
+	}
+	for k, v := range readCommandIO(dir) {
+		status[k] = v
+	}
+	status["children"] = strings.Join(findChildren(m.Proc, pid), ",")
 	return status
 }
 
+// readProcesses gives the status of each process by name.
+func readProcesses(ms []Monitor) map[string]map[string]string {
+	all := make(map[string]map[string]string)
+	for _, m := range ms {
+		all[m.Name] = m.readProcess()
+	}
+	return all
+}
+
+// readPid gives the pid written in the pidfile or the one of the first process
+// (with the lowest pid) whose command line matches Command.
+func (m Monitor) readPid() (string, error) {
+	if m.Pid != "" {
+		buf, err := ioutil.ReadFile(m.Pid)
+		return strings.TrimSpace(string(buf)), err
+	}
+	if m.Command == "" {
+		return "", os.ErrNotExist
+	}
+	es, err := ioutil.ReadDir(m.Proc)
+	if err != nil {
+		return "", err
+	}
+	var (
+		found = -1
+		self  = os.Getpid()
+	)
+	for _, e := range es {
+		n, err := strconv.Atoi(e.Name())
+		if err != nil || !e.IsDir() || n == self || (found >= 0 && n > found) {
+			continue
+		}
+		cmdline := readCommandLine(filepath.Join(m.Proc, e.Name()))
+		if cmdline != "" && m.matchCommand(cmdline) {
+			found = n
+		}
+	}
+	if found < 0 {
+		return "", os.ErrNotExist
+	}
+	return strconv.Itoa(found), nil
+}
+
+// matchCommand checks that a command line matches the configured command. The
+// command is used as a regular expression or as a plain string if it is not a
+// valid one. A pidfile whose pid has been reused by another command is then
+// reported as stale.
+func (m Monitor) matchCommand(cmdline string) bool {
+	if m.Command == "" {
+		return true
+	}
+	if m.command == nil {
+		return strings.Contains(cmdline, m.Command)
+	}
+	return m.command.MatchString(cmdline)
+}
+
 func readCommandLine(dir string) string {
 	buf, _ := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
-	return strings.ReplaceAll(string(buf), "\x00", " ")
+	return strings.TrimSpace(strings.ReplaceAll(string(buf), "\x00", " "))
 }
 
 func readCommandStatus(dir string) map[string]string {
@@ -47,6 +223,9 @@ func readCommandStatus(dir string) map[string]string {
 	scan := bufio.NewScanner(bytes.NewReader(buf))
 	for scan.Scan() {
 		parts := strings.SplitN(scan.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
 		switch field := strings.ToLower(parts[0]); field {
 		case "name", "pid", "state", "vmrss", "vmsize":
 			stats[field] = strings.TrimSpace(parts[1])
@@ -55,3 +234,199 @@ func readCommandStatus(dir string) map[string]string {
 	}
 	return stats
 }
+
+func readCommandIO(dir string) map[string]string {
+	stats := make(map[string]string)
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "io"))
+	if err != nil {
+		return stats
+	}
+	scan := bufio.NewScanner(bytes.NewReader(buf))
+	for scan.Scan() {
+		parts := strings.SplitN(scan.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch field := strings.TrimSpace(parts[0]); field {
+		case "rchar", "wchar", "read_bytes", "write_bytes":
+			stats[field] = strings.TrimSpace(parts[1])
+		default:
+		}
+	}
+	return stats
+}
+
+// procStat holds the fields of /proc/<pid>/stat used by the monitor. Times
+// are given in clock ticks.
+type procStat struct {
+	Parent  int
+	User    int64
+	System  int64
+	Threads int
+	Start   int64
+}
+
+func readCommandStat(dir string) (procStat, error) {
+	var st procStat
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return st, err
+	}
+	return parseStat(string(buf))
+}
+
+func parseStat(str string) (procStat, error) {
+	var st procStat
+	// the name of the command is between parenthesis and can contain spaces
+	ix := strings.LastIndexByte(str, ')')
+	if ix < 0 {
+		return st, strconv.ErrSyntax
+	}
+	// fields starts from the state (third field of the stat file)
+	fields := strings.Fields(str[ix+1:])
+	if len(fields) < 20 {
+		return st, strconv.ErrSyntax
+	}
+	var err error
+	if st.Parent, err = strconv.Atoi(fields[1]); err != nil {
+		return st, err
+	}
+	if st.User, err = strconv.ParseInt(fields[11], 10, 64); err != nil {
+		return st, err
+	}
+	if st.System, err = strconv.ParseInt(fields[12], 10, 64); err != nil {
+		return st, err
+	}
+	if st.Threads, err = strconv.Atoi(fields[17]); err != nil {
+		return st, err
+	}
+	if st.Start, err = strconv.ParseInt(fields[19], 10, 64); err != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+func readBootTime(proc string) (time.Time, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(proc, "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	scan := bufio.NewScanner(bytes.NewReader(buf))
+	for scan.Scan() {
+		fields := strings.Fields(scan.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			n, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(n, 0), nil
+		}
+	}
+	return time.Time{}, os.ErrNotExist
+}
+
+func countFiles(dir string) (int, error) {
+	es, err := ioutil.ReadDir(dir)
+	return len(es), err
+}
+
+// findChildren gives the pids of the processes whose parent is pid. They are
+// read from the children files of the threads of the process and, when the
+// kernel does not give them, from the stat files of all the processes.
+func findChildren(proc, pid string) []string {
+	children, err := readChildren(filepath.Join(proc, pid))
+	if err != nil {
+		children = scanChildren(proc, pid)
+	}
+	sort.Ints(children)
+	var str []string
+	for _, c := range children {
+		str = append(str, strconv.Itoa(c))
+	}
+	return str
+}
+
+func readChildren(dir string) ([]int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "task", "*", "children"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, os.ErrNotExist
+	}
+	var children []int
+	for _, f := range files {
+		buf, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, str := range strings.Fields(string(buf)) {
+			n, err := strconv.Atoi(str)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, n)
+		}
+	}
+	return children, nil
+}
+
+func scanChildren(proc, pid string) []int {
+	es, err := ioutil.ReadDir(proc)
+	if err != nil {
+		return nil
+	}
+	var children []int
+	for _, e := range es {
+		if !e.IsDir() || e.Name() == pid {
+			continue
+		}
+		n, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		st, err := readCommandStat(filepath.Join(proc, e.Name()))
+		if err == nil && strconv.Itoa(st.Parent) == pid {
+			children = append(children, n)
+		}
+	}
+	return children
+}
+
+// cpuSampler computes the CPU usage of a process from the difference of its
+// times between two reads at least cpuWindow apart. The first read gives the
+// average usage since the process started. The monitor of a process is shared
+// by all its readers (status, metrics, alerts, samples): the usage is only
+// computed again once the window elapsed and given as is until then.
+type cpuSampler struct {
+	mu    sync.Mutex
+	pid   string
+	ticks int64
+	when  time.Time
+	value float64
+}
+
+func (c *cpuSampler) usage(pid string, st procStat, started, now time.Time) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		ticks = st.User + st.System
+		prev  = c.ticks
+		since = started
+	)
+	if c.pid == pid && !c.when.IsZero() && ticks >= c.ticks {
+		if now.Sub(c.when) < cpuWindow {
+			return c.value
+		}
+		since = c.when
+	} else {
+		prev = 0
+	}
+	c.pid, c.ticks, c.when, c.value = pid, ticks, now, 0
+
+	if elapsed := now.Sub(since).Seconds(); elapsed > 0 {
+		c.value = float64(ticks-prev) / ClockTicks / elapsed * 100
+	}
+	return c.value
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProcess(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		boot = time.Now().Add(-time.Hour).Unix()
+	)
+	files := map[string]string{
+		"autobrm.pid": "42\n",
+		"stat":        "cpu  1 2 3 4\nbtime " + strconv.FormatInt(boot, 10) + "\n",
+		"42/cmdline":  "/usr/bin/autobrm\x00-c\x00autobrm.toml\x00",
+		"42/status":   "Name:\tautobrm\nState:\tS (sleeping)\nPid:\t42\nVmSize:\t  2048 kB\nVmRSS:\t  1024 kB\n",
+		"42/stat":     "42 (auto brm) S 1 42 42 0 -1 4194560 100 0 0 0 150 50 0 0 20 0 3 0 180000 2097152 256\n",
+		"42/io":       "rchar: 100\nwchar: 200\nread_bytes: 4096\nwrite_bytes: 8192\n",
+		"42/fd/0":     "",
+		"42/fd/1":     "",
+		"43/stat":     "43 (worker) S 42 42 42 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 190000 0 0\n",
+		"44/stat":     "44 (other) S 1 44 44 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 190000 0 0\n",
+		"other.pid":   "99\n",
+	}
+	for f, c := range files {
+		file := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mon := Monitor{Pid: filepath.Join(dir, "autobrm.pid"), Proc: dir, Command: "autobrm"}.withDefaults()
+
+	status := mon.readProcess()
+	want := map[string]string{
+		"name":        "autobrm",
+		"cmdline":     "/usr/bin/autobrm -c autobrm.toml",
+		"ppid":        "1",
+		"threads":     "3",
+		"fds":         "2",
+		"utime":       "1.50",
+		"stime":       "0.50",
+		"read_bytes":  "4096",
+		"write_bytes": "8192",
+		"children":    "43",
+		"stale":       "false",
+		"starttime":   time.Unix(boot+1800, 0).UTC().Format(time.RFC3339),
+	}
+	for k, v := range want {
+		if status[k] != v {
+			t.Errorf("%s: want %q, got %q", k, v, status[k])
+		}
+	}
+	if n, err := strconv.Atoi(status["uptime"]); err != nil || n < 1800 || n > 1810 {
+		t.Errorf("unexpected uptime: %s", status["uptime"])
+	}
+	if status["cpu"] == "" {
+		t.Errorf("cpu usage not computed")
+	}
+
+	mon.Command = "^/usr/sbin/httpd"
+	mon = mon.withDefaults()
+	if status := mon.readProcess(); status["stale"] != "true" {
+		t.Errorf("pidfile should be stale when command does not match")
+	}
+	mon.Pid = filepath.Join(dir, "other.pid")
+	if status := mon.readProcess(); status["stale"] != "true" || status["pid"] != "99" {
+		t.Errorf("pidfile should be stale when process does not exist: %v", status)
+	}
+}
+
+func TestFindChildren(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"42/task/42/children": "45 43 ",
+		"42/task/46/children": "47",
+		"43/stat":             "43 (worker) S 42 42 42 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 190000 0 0\n",
+	}
+	for f, c := range files {
+		file := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := strings.Join(findChildren(dir, "42"), ","); got != "43,45,47" {
+		t.Errorf("unexpected children from tasks: %s", got)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "42", "task")); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(findChildren(dir, "42"), ","); got != "43" {
+		t.Errorf("unexpected children from stat: %s", got)
+	}
+}
+
+func TestCPUSampler(t *testing.T) {
+	var (
+		c       cpuSampler
+		started = time.Now().Add(-100 * time.Second)
+		now     = time.Now()
+	)
+	if v := c.usage("42", procStat{User: 500, System: 500}, started, now); v < 9.9 || v > 10.1 {
+		t.Errorf("unexpected usage since start: %f", v)
+	}
+	if v := c.usage("42", procStat{User: 600, System: 600}, started, now.Add(time.Second)); v < 9.9 || v > 10.1 {
+		t.Errorf("usage should not change within the window: %f", v)
+	}
+	if v := c.usage("42", procStat{User: 1000, System: 1000}, started, now.Add(10*time.Second)); v < 99.9 || v > 100.1 {
+		t.Errorf("unexpected usage between reads: %f", v)
+	}
+	if v := c.usage("43", procStat{User: 100}, now, now.Add(10*time.Second)); v < 9.9 || v > 10.1 {
+		t.Errorf("unexpected usage after restart: %f", v)
+	}
+}
+
+func TestMonitors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"12/cmdline": "/usr/bin/vmu-extract\x00--daemon\x00",
+		"12/status":  "Name:\tvmu-extract\nState:\tS (sleeping)\nPid:\t12\n",
+		"7/cmdline":  "/usr/bin/vmu-extract\x00--worker\x00",
+		"7/status":   "Name:\tvmu-extract\nState:\tR (running)\nPid:\t7\n",
+		"8/cmdline":  "/usr/bin/hrd-archiver\x00",
+	}
+	for f, c := range files {
+		file := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := Config{
+		Mon: Monitor{Pid: filepath.Join(dir, "autobrm.pid"), Proc: dir},
+		Procs: []Monitor{
+			{Name: "extractor", Command: "vmu-extract"},
+			{Name: "daemon", Command: `vmu-extract\s+--daemon`},
+			{Name: "ingester", Command: "hrd-ingest"},
+		},
+	}
+	ms, err := conf.Monitors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 4 || ms[0].Name != DefaultProcess {
+		t.Fatalf("unexpected monitors: %v", ms)
+	}
+	status := readProcesses(ms)
+	want := map[string]string{
+		DefaultProcess: "unknown",
+		"extractor":    "7",
+		"daemon":       "12",
+		"ingester":     "unknown",
+	}
+	for n, pid := range want {
+		if got := status[n]["pid"]; got != pid {
+			t.Errorf("%s: unexpected pid: want %s, got %s", n, pid, got)
+		}
+	}
+
+	invalid := [][]Monitor{
+		{{Command: "vmu-extract"}},
+		{{Name: "extractor"}},
+		{{Name: "extractor", Command: "vmu-extract"}, {Name: "extractor", Pid: "extractor.pid"}},
+		{{Name: DefaultProcess, Pid: "autobrm.pid"}},
+	}
+	for _, ps := range invalid {
+		conf.Procs = ps
+		if _, err := conf.Monitors(); err == nil {
+			t.Errorf("expected error for %v", ps)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// NewSQLiteStore gives a Store backed by a SQLite database stored in file. The
+// migrations not yet applied to the database are run first.
+func NewSQLiteStore(file string, mons []Monitor) (Store, error) {
+	db, err := openSQLite(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateUp(db, DriverSQLite); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("fail to migrate schema: %w", err)
+	}
+	if err := checkSchema(context.Background(), db, DriverSQLite); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := DBStore{
+		db:      db,
+		driver:  DriverSQLite,
+		mon:     mons[0],
+		procs:   mons,
+		samples: newSampleRings(mons),
+	}
+	return s, nil
+}
+
+func openSQLite(file string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite", file)
+	db, err := sql.Open(DriverSQLite, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("fail to connect: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newSQLiteTest(t *testing.T) DBStore {
+	t.Helper()
+	db, err := NewSQLiteStore(filepath.Join(t.TempDir(), "otto.db"), []Monitor{{Name: "autobrm"}})
+	if err != nil {
+		t.Fatalf("fail to open store: %s", err)
+	}
+	s := db.(DBStore)
+	t.Cleanup(func() {
+		s.db.Close()
+	})
+	return s
+}
+
+// seedSQLiteGap adds an HRD gap to the store and gives its id.
+func seedSQLiteGap(t *testing.T, s DBStore, when time.Time, channel string, first, last int) int {
+	t.Helper()
+	const q = `insert into hrd_packet_gap(timestamp, chanel, last_sequence_count, last_timestamp, next_sequence_count, next_timestamp) values (?, ?, ?, ?, ?, ?)`
+	res, err := s.db.Exec(q, when, channel, first, when, last, when.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("fail to seed gap: %s", err)
+	}
+	id, _ := res.LastInsertId()
+	return int(id)
+}
+
+func countGapLinks(t *testing.T, s DBStore, gap int) int {
+	t.Helper()
+	var n int
+	if err := s.db.QueryRow(`select count(*) from gap_replay_list where hrd_packet_gap_id=?`, gap).Scan(&n); err != nil {
+		t.Fatalf("fail to count links: %s", err)
+	}
+	return n
+}
+
+func TestSQLiteRegisterUnlinkedGap(t *testing.T) {
+	s := newSQLiteTest(t)
+	id := seedSQLiteGap(t, s, time.Now().AddDate(0, 0, -100).UTC(), "vic1", 10, 20)
+
+	g, err := s.FetchGapDetailHRD(context.Background(), id)
+	if err != nil {
+		t.Fatalf("unlinked gap not found: %s", err)
+	}
+	if g.Replay != 0 || g.Request != nil {
+		t.Errorf("unlinked gap with a replay: %d", g.Replay)
+	}
+	handler, err := setupRoutes(s, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := serveRequest(handler, http.MethodPost, "/requests/gaps/", `{"hrd": [1]}`); code != http.StatusCreated {
+		t.Fatalf("unexpected status code: want %d, got %d", http.StatusCreated, code)
+	}
+	if g, err = s.FetchGapDetailHRD(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if g.Replay != 1 {
+		t.Errorf("gap not linked to the new replay: want 1, got %d", g.Replay)
+	}
+	r, err := s.FetchReplayDetail(context.Background(), g.Replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.HRD) != 1 || r.HRD[0].Id != id {
+		t.Errorf("gap not given with its replay: %+v", r.HRD)
+	}
+}
+
+func TestSQLiteRegisterLinkedGap(t *testing.T) {
+	s := newSQLiteTest(t)
+	id := seedSQLiteGap(t, s, time.Now().UTC(), "vic1", 10, 20)
+
+	handler, err := setupRoutes(s, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := serveRequest(handler, http.MethodPost, "/requests/gaps/", `{"hrd": [1]}`); code != http.StatusCreated {
+		t.Fatalf("first: unexpected status code: want %d, got %d", http.StatusCreated, code)
+	}
+	if code := serveRequest(handler, http.MethodPost, "/requests/gaps/?overlap=extend", `{"hrd": [1]}`); code != http.StatusCreated {
+		t.Fatalf("extend: unexpected status code: want %d, got %d", http.StatusCreated, code)
+	}
+	if n := countGapLinks(t, s, id); n != 1 {
+		t.Errorf("gap linked twice to the same replay: want 1 link, got %d", n)
+	}
+	if _, err := s.CancelReplay(context.Background(), 1, "cancelled"); err != nil {
+		t.Fatal(err)
+	}
+	if code := serveRequest(handler, http.MethodPost, "/requests/gaps/", `{"hrd": [1]}`); code != http.StatusCreated {
+		t.Fatalf("second: unexpected status code: want %d, got %d", http.StatusCreated, code)
+	}
+	if n := countGapLinks(t, s, id); n != 1 {
+		t.Errorf("gap linked to two replays: want 1 link, got %d", n)
+	}
+	_, gs, err := s.FetchGapsHRD(context.Background(), Criteria{Completed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gs) != 1 || gs[0].Replay != 2 {
+		t.Errorf("gap not listed once with its last replay: %+v", gs)
+	}
+	var missing int
+	if err := s.db.QueryRow(`select coalesce(sum(count), 0) from hrd_status_list where label='MISSING'`).Scan(&missing); err != nil {
+		t.Fatal(err)
+	}
+	if missing != 10 {
+		t.Errorf("missing packets counted more than once: want 10, got %d", missing)
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	var (
+		s     = newSQLiteTest(t)
+		ctx   = context.Background()
+		start = time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	)
+	rs, err := s.RegisterReplay(ctx, Replay{Period: Period{Starts: start, Ends: start.Add(time.Minute)}, Priority: 1}, OverlapReject)
+	if err != nil {
+		t.Fatalf("register replay: %s", err)
+	}
+	if len(rs) != 1 {
+		t.Fatalf("unexpected number of replays: want 1, got %d", len(rs))
+	}
+	r, err := s.FetchReplayDetail(ctx, rs[0].Id)
+	if err != nil {
+		t.Fatalf("fetch replay: %s", err)
+	}
+	if !r.Starts.Equal(start) || len(r.Jobs) != 1 {
+		t.Errorf("unexpected replay: %+v", r)
+	}
+
+	id := seedSQLiteGap(t, s, start, "vic2", 4294967290, 5)
+	g, err := s.FetchGapDetailHRD(ctx, id)
+	if err != nil {
+		t.Fatalf("fetch gap: %s", err)
+	}
+	if g.Channel != "vic2" || g.Missing != 11 {
+		t.Errorf("unexpected gap: %+v", g)
+	}
+
+	v, err := s.RegisterVariable(ctx, Variable{Name: "gap_padding", Value: "10", Type: TypeInt})
+	if err != nil {
+		t.Fatalf("register variable: %s", err)
+	}
+	if _, err := s.UpdateVariable(ctx, v.Id, "20"); err != nil {
+		t.Fatalf("update variable: %s", err)
+	}
+	if v, err = s.FetchVariable(ctx, v.Id); err != nil {
+		t.Fatalf("fetch variable: %s", err)
+	}
+	if v.Value != "20" {
+		t.Errorf("unexpected value: want 20, got %s", v.Value)
+	}
+}
+
+func TestSQLiteMigrate(t *testing.T) {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "otto.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ms, err := loadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateUp(db, DriverSQLite); err != nil {
+		t.Fatalf("migrate up: %s", err)
+	}
+	if v, _ := currentVersion(context.Background(), db); v != ms[len(ms)-1].Version {
+		t.Fatalf("unexpected version: want %d, got %d", ms[len(ms)-1].Version, v)
+	}
+	for range ms {
+		if err := migrateDown(db, DriverSQLite); err != nil {
+			t.Fatalf("migrate down: %s", err)
+		}
+	}
+	var n int
+	if err := db.QueryRow(`select count(*) from sqlite_master where type in ('table', 'view') and name not in ('schema_version', 'sqlite_sequence')`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("objects left after migrating down: %d", n)
+	}
+	if err := migrateUp(db, DriverSQLite); err != nil {
+		t.Fatalf("migrate up again: %s", err)
+	}
+}
+
+func TestSQLiteGapEventCompleted(t *testing.T) {
+	s := newSQLiteTest(t)
+	seedSQLiteGap(t, s, time.Now().UTC(), "vic1", 10, 20)
+	seedSQLiteGap(t, s, time.Now().UTC().Add(time.Hour), "vic1", 30, 40)
+
+	handler, err := setupRoutes(s, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := serveRequest(handler, http.MethodPost, "/requests/gaps/", `{"hrd": [1]}`); code != http.StatusCreated {
+		t.Fatalf("unexpected status code: want %d, got %d", http.StatusCreated, code)
+	}
+	const q = `insert into replay_job(timestamp, replay_id, replay_status_id, text) select ?, 1, id, '' from replay_status where name='completed'`
+	if _, err := s.db.Exec(q, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	es, err := s.FetchEvents(context.Background(), EventCursor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	completed := make(map[int]bool)
+	for _, e := range es {
+		if g, ok := e.Data.(HRDGap); ok && e.Type == EventGapHRD {
+			completed[g.Id] = g.Completed
+		}
+	}
+	if len(completed) != 2 {
+		t.Fatalf("unexpected number of gap events: want 2, got %d", len(completed))
+	}
+	if !completed[1] {
+		t.Errorf("gap of a completed replay not flagged as completed")
+	}
+	if completed[2] {
+		t.Errorf("unlinked gap flagged as completed")
+	}
+}
+
+func TestSQLiteFetchReplaysStatus(t *testing.T) {
+	var (
+		s     = newSQLiteTest(t)
+		ctx   = context.Background()
+		now   = time.Now().UTC()
+		start = now.Add(-time.Hour)
+	)
+	for i := 0; i < 2; i++ {
+		r := Replay{Period: Period{Starts: start.Add(time.Duration(i) * time.Minute), Ends: start.Add(time.Duration(i+1) * time.Minute)}}
+		if _, err := s.RegisterReplay(ctx, r, OverlapReject); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.CancelReplay(ctx, 1, "cancelled"); err != nil {
+		t.Fatal(err)
+	}
+	data := []struct {
+		Criteria
+		Want int
+	}{
+		{Criteria: Criteria{Status: "pending"}, Want: 1},
+		{Criteria: Criteria{Status: "cancelled"}, Want: 1},
+		{Criteria: Criteria{Status: "pending", Period: Period{Starts: now.Add(-time.Minute), Ends: now.Add(time.Minute)}}, Want: 1},
+		{Criteria: Criteria{Status: "pending", Period: Period{Starts: now.Add(time.Hour), Ends: now.Add(2 * time.Hour)}}, Want: 0},
+	}
+	for _, d := range data {
+		_, rs, err := s.FetchReplays(ctx, d.Criteria)
+		if err != nil {
+			t.Fatalf("%s: %s", d.Status, err)
+		}
+		if len(rs) != d.Want {
+			t.Errorf("%s (%s - %s): unexpected number of replays: want %d, got %d", d.Status, d.Starts.Format(time.RFC3339), d.Ends.Format(time.RFC3339), d.Want, len(rs))
+		}
+	}
+}
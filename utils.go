@@ -2,9 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"time"
 
@@ -83,6 +85,19 @@ func parsePeriod(r *http.Request) (time.Time, time.Time, error) {
 	return start, end, nil
 }
 
+func parseOverlap(str string) (Overlap, error) {
+	switch str {
+	case "", "reject":
+		return OverlapReject, nil
+	case "extend":
+		return OverlapExtend, nil
+	case "split":
+		return OverlapSplit, nil
+	default:
+		return OverlapReject, fmt.Errorf("%s: invalid overlap mode", str)
+	}
+}
+
 func parseDatetime(str string) (time.Time, error) {
 	var (
 		when time.Time
@@ -96,3 +111,107 @@ func parseDatetime(str string) (time.Time, error) {
 	}
 	return when, err
 }
+
+// GapGroup is a period covering gaps close to each other. The gaps keep their
+// own period to be linked only to the replays covering them.
+type GapGroup struct {
+	Period
+	HRD []Gap
+	VMU []Gap
+}
+
+// within gives the ids of the HRD and VMU gaps of the group overlapping p. All
+// the gaps are given for the period of the group itself.
+func (g GapGroup) within(p Period) ([]int, []int) {
+	all := p.Starts.Equal(g.Starts) && p.Ends.Equal(g.Ends)
+	return gapsWithin(g.HRD, p, all), gapsWithin(g.VMU, p, all)
+}
+
+func gapsWithin(gs []Gap, p Period, all bool) []int {
+	var ids []int
+	for _, g := range gs {
+		if all || g.overlaps(p) {
+			ids = append(ids, g.Id)
+		}
+	}
+	return ids
+}
+
+// groupGaps computes the periods covering the given gaps, each one extended by
+// pad on both sides. Gaps whose periods are separated by less than interval are
+// merged into the same group.
+func groupGaps(hrd []HRDGap, vmu []VMUGap, pad, interval time.Duration) []GapGroup {
+	var gs []GapGroup
+	for _, g := range hrd {
+		gg := GapGroup{
+			Period: g.Period,
+			HRD:    []Gap{g.Gap},
+		}
+		gs = append(gs, gg)
+	}
+	for _, g := range vmu {
+		gg := GapGroup{
+			Period: g.Period,
+			VMU:    []Gap{g.Gap},
+		}
+		gs = append(gs, gg)
+	}
+	if len(gs) == 0 {
+		return nil
+	}
+	for i := range gs {
+		gs[i].Starts = gs[i].Starts.Add(-pad)
+		gs[i].Ends = gs[i].Ends.Add(pad)
+	}
+	sort.Slice(gs, func(i, j int) bool {
+		return gs[i].Starts.Before(gs[j].Starts)
+	})
+	var (
+		rs   []GapGroup
+		curr = gs[0]
+	)
+	for _, g := range gs[1:] {
+		if g.Starts.Sub(curr.Ends) > interval {
+			rs = append(rs, curr)
+			curr = g
+			continue
+		}
+		if g.Ends.After(curr.Ends) {
+			curr.Ends = g.Ends
+		}
+		curr.HRD = append(curr.HRD, g.HRD...)
+		curr.VMU = append(curr.VMU, g.VMU...)
+	}
+	return append(rs, curr)
+}
+
+// subtractPeriods gives the parts of p that are not covered by any of the
+// given periods.
+func subtractPeriods(p Period, others []Period) []Period {
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].Starts.Before(others[j].Starts)
+	})
+	var (
+		ps   []Period
+		curr = p.Starts
+	)
+	for _, o := range others {
+		if !curr.Before(p.Ends) {
+			break
+		}
+		if o.Starts.After(curr) {
+			end := o.Starts
+			if end.After(p.Ends) {
+				end = p.Ends
+			}
+			ps = append(ps, Period{Starts: curr, Ends: end})
+		}
+		if o.Ends.After(curr) {
+			curr = o.Ends
+		}
+	}
+	if curr.Before(p.Ends) {
+		ps = append(ps, Period{Starts: curr, Ends: p.Ends})
+	}
+	return ps
+}
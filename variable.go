@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TypeString   = "string"
+	TypeInt      = "int"
+	TypeFloat    = "float"
+	TypeBool     = "bool"
+	TypeDuration = "duration"
+	TypeEnum     = "enum"
+)
+
+const (
+	ChangePending   = "pending"
+	ChangeConfirmed = "confirmed"
+	ChangeDiscarded = "discarded"
+)
+
+// newPendingChange prepares the change of the value of v for the user attached
+// to ctx. The token of the change lets its author confirm it until it
+// expires.
+func newPendingChange(ctx context.Context, v Variable, value string, window time.Duration) (PendingChange, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return PendingChange{}, err
+	}
+	now := time.Now().UTC()
+	p := PendingChange{
+		Variable: v.Id,
+		When:     now,
+		Expires:  now.Add(window),
+		Author:   actorFromContext(ctx),
+		Value:    value,
+		Token:    hex.EncodeToString(buf),
+	}
+	return p, nil
+}
+
+// canConfirm checks that the user attached to ctx can confirm p. Another user
+// can always confirm it. Its author needs to give the token of the change
+// before it expires.
+func (p PendingChange) canConfirm(ctx context.Context, token string) error {
+	if actorFromContext(ctx) != p.Author {
+		return nil
+	}
+	if token == "" {
+		return fmt.Errorf("%w: change %d should be confirmed by another user or with its token", ErrForbidden, p.Id)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(p.Token)) != 1 {
+		return fmt.Errorf("%w: invalid token for change %d", ErrForbidden, p.Id)
+	}
+	if time.Now().After(p.Expires) {
+		return fmt.Errorf("%w: token for change %d expired", ErrForbidden, p.Id)
+	}
+	return nil
+}
+
+// FieldError reports an invalid value given for one field of a request.
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+}
+
+func (e FieldError) Unwrap() error {
+	return ErrQuery
+}
+
+// setRange fills the Range of a variable from its min and max values or from
+// its allowed values if it is an enum.
+func (v *Variable) setRange(min, max, allowed string) {
+	switch v.Type {
+	case TypeInt, TypeFloat, TypeDuration:
+		if min != "" || max != "" {
+			v.Range = []string{min, max}
+		}
+	case TypeEnum:
+		for _, a := range strings.Split(allowed, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				v.Range = append(v.Range, a)
+			}
+		}
+	}
+}
+
+// rangeValues gives the min, max and allowed values of a variable as stored
+// in the variable table. It is the reverse of setRange.
+func (v Variable) rangeValues() (string, string, string) {
+	switch v.Type {
+	case TypeInt, TypeFloat, TypeDuration:
+		min, max := v.rangeLimits()
+		return min, max, ""
+	case TypeEnum:
+		return "", "", strings.Join(v.Range, ",")
+	default:
+		return "", "", ""
+	}
+}
+
+// rangeLimits gives the min and max values of a variable. Both are empty if
+// the variable has no range.
+func (v Variable) rangeLimits() (string, string) {
+	if len(v.Range) != 2 {
+		return "", ""
+	}
+	return v.Range[0], v.Range[1]
+}
+
+// ValidateDefinition checks the name, the type and the range of a new variable
+// and then its value.
+func (v Variable) ValidateDefinition() error {
+	if strings.TrimSpace(v.Name) == "" {
+		return FieldError{Field: "name", Reason: "empty name"}
+	}
+	switch v.Type {
+	case "", TypeString, TypeBool:
+		if len(v.Range) > 0 {
+			return FieldError{Field: "range", Reason: fmt.Sprintf("no range expected for %s", v.Type)}
+		}
+	case TypeInt, TypeFloat, TypeDuration:
+		if len(v.Range) != 0 && len(v.Range) != 2 {
+			return FieldError{Field: "range", Reason: "min and max values expected"}
+		}
+		for _, r := range v.Range {
+			if r == "" {
+				continue
+			}
+			if err := (Variable{Type: v.Type}).Validate(r); err != nil {
+				return FieldError{Field: "range", Reason: fmt.Sprintf("%s: not a valid %s", r, v.Type)}
+			}
+		}
+	case TypeEnum:
+		if len(v.Range) == 0 {
+			return FieldError{Field: "range", Reason: "allowed values expected"}
+		}
+		for _, r := range v.Range {
+			if r == "" || strings.Contains(r, ",") {
+				return FieldError{Field: "range", Reason: fmt.Sprintf("invalid allowed value %q", r)}
+			}
+		}
+	default:
+		return FieldError{Field: "type", Reason: fmt.Sprintf("unknown type %s", v.Type)}
+	}
+	return v.Validate(v.Value)
+}
+
+// Validate checks that value can be parsed according to the type of the
+// variable and that it is in its range.
+func (v Variable) Validate(value string) error {
+	switch v.Type {
+	case "", TypeString:
+		return nil
+	case TypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return FieldError{Field: "value", Reason: "not a boolean"}
+		}
+		return nil
+	case TypeEnum:
+		for _, a := range v.Range {
+			if a == value {
+				return nil
+			}
+		}
+		return FieldError{Field: "value", Reason: fmt.Sprintf("expected one of %s", strings.Join(v.Range, ", "))}
+	case TypeInt:
+		return v.validateNumber(value, func(str string) (float64, error) {
+			n, err := strconv.ParseInt(str, 0, 64)
+			return float64(n), err
+		})
+	case TypeFloat:
+		return v.validateNumber(value, func(str string) (float64, error) {
+			return strconv.ParseFloat(str, 64)
+		})
+	case TypeDuration:
+		return v.validateNumber(value, func(str string) (float64, error) {
+			d, err := time.ParseDuration(str)
+			return float64(d), err
+		})
+	default:
+		return FieldError{Field: "type", Reason: fmt.Sprintf("unknown type %s", v.Type)}
+	}
+}
+
+func (v Variable) validateNumber(value string, parse func(string) (float64, error)) error {
+	n, err := parse(value)
+	if err != nil {
+		return FieldError{Field: "value", Reason: fmt.Sprintf("not a valid %s", v.Type)}
+	}
+	min, max := v.rangeLimits()
+	if min != "" {
+		if m, err := parse(min); err == nil && n < m {
+			return FieldError{Field: "value", Reason: fmt.Sprintf("lower than %s", min)}
+		}
+	}
+	if max != "" {
+		if m, err := parse(max); err == nil && n > m {
+			return FieldError{Field: "value", Reason: fmt.Sprintf("greater than %s", max)}
+		}
+	}
+	return nil
+}
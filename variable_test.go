@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateVariable(t *testing.T) {
+	data := []struct {
+		Variable
+		Value string
+		Valid bool
+	}{
+		{Variable: Variable{Type: TypeString}, Value: "anything", Valid: true},
+		{Variable: Variable{Type: TypeInt}, Value: "15", Valid: true},
+		{Variable: Variable{Type: TypeInt}, Value: "1.5", Valid: false},
+		{Variable: Variable{Type: TypeInt, Range: []string{"1", "365"}}, Value: "365", Valid: true},
+		{Variable: Variable{Type: TypeInt, Range: []string{"1", "365"}}, Value: "0", Valid: false},
+		{Variable: Variable{Type: TypeInt, Range: []string{"", "10"}}, Value: "-5", Valid: true},
+		{Variable: Variable{Type: TypeFloat, Range: []string{"0", "1"}}, Value: "0.5", Valid: true},
+		{Variable: Variable{Type: TypeFloat, Range: []string{"0", "1"}}, Value: "1.5", Valid: false},
+		{Variable: Variable{Type: TypeBool}, Value: "true", Valid: true},
+		{Variable: Variable{Type: TypeBool}, Value: "yes", Valid: false},
+		{Variable: Variable{Type: TypeDuration, Range: []string{"1m", "1h"}}, Value: "30m", Valid: true},
+		{Variable: Variable{Type: TypeDuration, Range: []string{"1m", "1h"}}, Value: "2h", Valid: false},
+		{Variable: Variable{Type: TypeDuration}, Value: "10", Valid: false},
+		{Variable: Variable{Type: TypeEnum, Range: []string{"low", "high"}}, Value: "high", Valid: true},
+		{Variable: Variable{Type: TypeEnum, Range: []string{"low", "high"}}, Value: "medium", Valid: false},
+		{Variable: Variable{Type: "date"}, Value: "2020-01-01", Valid: false},
+	}
+	for _, d := range data {
+		err := d.Validate(d.Value)
+		if d.Valid && err != nil {
+			t.Errorf("%s(%s): unexpected error: %s", d.Type, d.Value, err)
+		}
+		if !d.Valid {
+			if err == nil {
+				t.Errorf("%s(%s): expected error", d.Type, d.Value)
+			} else if !errors.Is(err, ErrQuery) {
+				t.Errorf("%s(%s): expected query error, got %s", d.Type, d.Value, err)
+			}
+		}
+	}
+}
+
+func TestSetRange(t *testing.T) {
+	v := Variable{Type: TypeEnum}
+	v.setRange("", "", "low, medium,high")
+	if len(v.Range) != 3 || v.Range[1] != "medium" {
+		t.Errorf("unexpected enum range: %v", v.Range)
+	}
+	v = Variable{Type: TypeInt}
+	v.setRange("", "", "")
+	if v.Range != nil {
+		t.Errorf("unexpected int range: %v", v.Range)
+	}
+	v.setRange("1", "", "")
+	if len(v.Range) != 2 || v.Range[0] != "1" || v.Range[1] != "" {
+		t.Errorf("unexpected int range: %v", v.Range)
+	}
+}
+
+func TestValidateDefinition(t *testing.T) {
+	data := []struct {
+		Variable
+		Field string
+	}{
+		{Variable: Variable{Name: "mode", Type: TypeEnum, Value: "auto", Range: []string{"auto", "manual"}}},
+		{Variable: Variable{Name: "days", Type: TypeInt, Value: "10", Range: []string{"1", ""}}},
+		{Variable: Variable{Name: "", Type: TypeString, Value: "foo"}, Field: "name"},
+		{Variable: Variable{Name: "mode", Type: "date", Value: "2020-01-01"}, Field: "type"},
+		{Variable: Variable{Name: "mode", Type: TypeEnum, Value: "auto"}, Field: "range"},
+		{Variable: Variable{Name: "mode", Type: TypeEnum, Value: "auto", Range: []string{"auto", "a,b"}}, Field: "range"},
+		{Variable: Variable{Name: "days", Type: TypeInt, Value: "10", Range: []string{"1"}}, Field: "range"},
+		{Variable: Variable{Name: "days", Type: TypeInt, Value: "10", Range: []string{"one", "ten"}}, Field: "range"},
+		{Variable: Variable{Name: "flag", Type: TypeBool, Value: "true", Range: []string{"true"}}, Field: "range"},
+		{Variable: Variable{Name: "days", Type: TypeInt, Value: "100", Range: []string{"1", "10"}}, Field: "value"},
+	}
+	for _, d := range data {
+		err := d.ValidateDefinition()
+		if d.Field == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", d.Name, err)
+			}
+			continue
+		}
+		var fe FieldError
+		if !errors.As(err, &fe) {
+			t.Errorf("%s: expected field error, got %v", d.Name, err)
+			continue
+		}
+		if fe.Field != d.Field {
+			t.Errorf("%s: unexpected field: want %s, got %s", d.Name, d.Field, fe.Field)
+		}
+	}
+}